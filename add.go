@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/callebjorkell/rpi-nfc-player/library"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
 	"github.com/callebjorkell/rpi-nfc-player/sonos"
 	log "github.com/sirupsen/logrus"
 )
@@ -19,7 +21,7 @@ func storeAlbum(id uint64, cardId string) {
 	}
 	p := sonos.FromAlbum(a, cardId)
 
-	db.StoreCard(p)
+	storeCard(p)
 }
 
 func storePlaylist(id uint64, cardId string) {
@@ -34,7 +36,102 @@ func storePlaylist(id uint64, cardId string) {
 	}
 	pl := sonos.FromPlaylist(p, cardId)
 
-	db.StoreCard(pl)
+	storeCard(pl)
+}
+
+func storeLocal(path string, cardId string) {
+	a, err := library.GetAlbum(path)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if cardId == "" {
+		cardId = getCardId()
+	}
+	l := sonos.FromLocal(a, cardId)
+
+	storeCard(l)
+}
+
+func storeStream(url, name, logo string, cardId string) {
+	s, err := radio.New(url, name, logo)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if cardId == "" {
+		cardId = getCardId()
+	}
+	r := sonos.FromStation(s, cardId)
+
+	storeCard(r)
+}
+
+func storeServiceItem(service, albumId, playlistId, title string, cardId string) {
+	if cardId == "" {
+		cardId = getCardId()
+	}
+	if title == "" {
+		// keep the title when moving a card to another service, since the label on it stays the same.
+		if old, err := db.ReadCard(cardId); err == nil {
+			title = old.Title
+		}
+	}
+
+	c, err := sonos.FromService(service, albumId, playlistId, title, cardId)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	storeCard(c)
+}
+
+func storeFavorite(id, speaker string, cardId string) {
+	s, err := sonos.New(speaker)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	f, err := s.Favorite(id)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if cardId == "" {
+		cardId = getCardId()
+	}
+	c := sonos.FromFavorite(f, cardId)
+
+	storeCard(c)
+}
+
+func storeAction(action string, cardId string) {
+	a, err := sonos.ParseAction(action)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if cardId == "" {
+		cardId = getCardId()
+	}
+	c := sonos.FromAction(a, cardId)
+
+	storeCard(c)
+}
+
+// storeCard applies the settings that are common for all kinds of cards, and then stores the card.
+func storeCard(c *sonos.CardInfo) {
+	if *addSleep > 0 {
+		c.SleepTimer = addSleep.String()
+	}
+	if err := db.StoreCard(c); err != nil {
+		log.Error(err)
+	}
 }
 
 func getCardId() string {
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/callebjorkell/rpi-nfc-player/library"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"github.com/nfnt/resize"
+	log "github.com/sirupsen/logrus"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	coverSize      = 300
+	defaultHistory = 50
+)
+
+// apiServer is the HTTP API that cards can be managed and the player controlled through.
+type apiServer struct {
+	token   string
+	player  *player.Player
+	speaker *sonos.SonosSpeaker
+
+	// covers caches the cover art of the cards as PNG, since fetching and scaling it is slow.
+	covers     map[string][]byte
+	coversLock sync.Mutex
+}
+
+// cardRequest describes what to put on a card. Exactly like the add command, one of the kinds of cards should be
+// filled in.
+type cardRequest struct {
+	AlbumID           uint64 `json:"albumId"`
+	PlaylistID        uint64 `json:"playlistId"`
+	Local             string `json:"local"`
+	Stream            string `json:"stream"`
+	Name              string `json:"name"`
+	Logo              string `json:"logo"`
+	Service           string `json:"service"`
+	ServiceAlbumID    string `json:"serviceAlbumId"`
+	ServicePlaylistID string `json:"servicePlaylistId"`
+	Title             string `json:"title"`
+	Favorite          string `json:"favorite"`
+	Action            string `json:"action"`
+	Sleep             string `json:"sleep"`
+}
+
+type stateResponse struct {
+	Speaker string           `json:"speaker"`
+	Player  player.Status    `json:"player"`
+	Track   *sonos.TrackInfo `json:"track,omitempty"`
+}
+
+// serveAPI starts serving the API on the given address.
+func serveAPI(addr, token string, p *player.Player, s *sonos.SonosSpeaker) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &apiServer{token: token, player: p, speaker: s, covers: make(map[string][]byte)}
+	api := http.NewServeMux()
+	api.HandleFunc("/api/cards", a.cards)
+	api.HandleFunc("/api/cards/", a.card)
+	api.HandleFunc("/api/search", a.search)
+	api.HandleFunc("/api/state", a.state)
+	api.HandleFunc("/api/player/", a.command)
+	api.HandleFunc("/api/assign", a.assign)
+	api.HandleFunc("/api/history", a.history)
+	api.HandleFunc("/api/labels", a.labels)
+	api.HandleFunc("/api/labels/sheet", a.sheet)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", a.authenticate(api))
+	mux.Handle("/", webHandler())
+
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Error("HTTP API stopped: ", err)
+		}
+	}()
+	log.Infof("Serving the HTTP API on %v", l.Addr())
+	return l, nil
+}
+
+// authenticate only lets requests through if they carry the token, either as a bearer token or as a token query
+// parameter.
+func (a *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *apiServer) cards(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	cards, err := db.ReadAll()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if *cards == nil {
+		*cards = []sonos.CardInfo{}
+	}
+	writeJSON(w, http.StatusOK, cards)
+}
+
+func (a *apiServer) card(w http.ResponseWriter, r *http.Request) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/cards/"), "/")
+	if id == "" {
+		writeError(w, http.StatusNotFound, errors.New("no such card"))
+		return
+	}
+	switch sub {
+	case "":
+	case "cover":
+		a.cover(w, r, id)
+		return
+	case "label":
+		a.label(w, r, id)
+		return
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		c, err := db.ReadCard(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodPut:
+		c, ok := a.readCard(w, r)
+		if !ok {
+			return
+		}
+		c.ID = id
+		if c.Title == "" {
+			// keep the title of cards that don't come with one, since the label on the card stays the same.
+			if old, err := db.ReadCard(id); err == nil {
+				c.Title = old.Title
+			}
+		}
+		if err := db.StoreCard(c); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := a.player.CardAdded(id); err != nil {
+			log.Warn("Could not tell the player about the card: ", err)
+		}
+		a.forgetCover(id)
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodDelete:
+		if err := db.DeleteCard(id); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err := a.player.CardDeleted(id); err != nil {
+			log.Warn("Could not tell the player about the card: ", err)
+		}
+		a.forgetCover(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// cover serves the cover art of a card, scaled down to fit a list.
+func (a *apiServer) cover(w http.ResponseWriter, r *http.Request, id string) {
+	a.coversLock.Lock()
+	data, ok := a.covers[id]
+	a.coversLock.Unlock()
+
+	if !ok {
+		p, err := getPlayable(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		art := p.CoverArt()
+		if art == nil {
+			writeError(w, http.StatusNotFound, errors.New("no cover art"))
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resize.Thumbnail(coverSize, coverSize, *art, resize.Bilinear)); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		data = buf.Bytes()
+
+		a.coversLock.Lock()
+		a.covers[id] = data
+		a.coversLock.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(data)
+}
+
+func (a *apiServer) forgetCover(id string) {
+	a.coversLock.Lock()
+	delete(a.covers, id)
+	a.coversLock.Unlock()
+}
+
+// label serves the label of a card, ready to be printed.
+func (a *apiServer) label(w http.ResponseWriter, r *http.Request, id string) {
+	p, err := getPlayable(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := deezer.CreateLabel(p, &buf); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writePNG(w, fmt.Sprintf("%v.png", p.Id()), buf.Bytes())
+}
+
+// labels tells how many sheets the labels of all the cards fill.
+func (a *apiServer) labels(w http.ResponseWriter, r *http.Request) {
+	cards, err := db.ReadAll()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	step := deezer.LabelsPerSheet
+	writeJSON(w, http.StatusOK, map[string]int{
+		"perSheet": step,
+		"sheets":   (len(*cards) + step - 1) / step,
+	})
+}
+
+// sheet serves a sheet of labels for batch printing. Without any card parameters, all the cards are put on sheets,
+// and the page parameter picks which one.
+func (a *apiServer) sheet(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 0
+	}
+
+	var cards []sonos.CardInfo
+	if ids := r.URL.Query()["card"]; len(ids) > 0 {
+		for _, id := range ids {
+			c, err := db.ReadCard(id)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			cards = append(cards, c)
+		}
+	} else {
+		all, err := db.ReadAll()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		cards = *all
+	}
+
+	step := deezer.LabelsPerSheet
+	if page < 0 || page*step >= len(cards) {
+		writeError(w, http.StatusNotFound, errors.New("no such page"))
+		return
+	}
+	var lists []deezer.Playable
+	for _, c := range cards[page*step : min((page+1)*step, len(cards))] {
+		p, err := c.ToPlayable()
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		lists = append(lists, p)
+	}
+
+	var buf bytes.Buffer
+	if err := deezer.CreateLabelSheet(lists, &buf); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writePNG(w, fmt.Sprintf("sheet%v.png", page), buf.Bytes())
+}
+
+func (a *apiServer) history(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultHistory
+	}
+	plays, err := db.ReadHistory(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if plays == nil {
+		plays = []player.Play{}
+	}
+	writeJSON(w, http.StatusOK, plays)
+}
+
+func (a *apiServer) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing query parameter q"))
+		return
+	}
+	res, err := deezer.Search(q)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (a *apiServer) state(w http.ResponseWriter, r *http.Request) {
+	res := stateResponse{
+		Speaker: a.speaker.Name(),
+		Player:  a.player.Status(),
+	}
+	if res.Player.State == player.Playing || res.Player.State == player.Paused {
+		if t, err := a.speaker.NowPlaying(); err == nil {
+			res.Track = &t
+		} else {
+			log.Debug("Could not read what is playing: ", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (a *apiServer) command(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	cmd := player.Command(strings.TrimPrefix(r.URL.Path, "/api/player/"))
+	switch err := a.player.Command(cmd); err {
+	case nil:
+		writeJSON(w, http.StatusOK, a.player.Status())
+	case player.ErrUnknownCommand:
+		writeError(w, http.StatusNotFound, err)
+	case player.ErrNoReply:
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusConflict, err)
+	}
+}
+
+// assign makes the next card that is put on the box get the card in the request. A DELETE cancels it.
+func (a *apiServer) assign(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		c, ok := a.readCard(w, r)
+		if !ok {
+			return
+		}
+		err = a.player.Assign(c)
+	case http.MethodDelete:
+		err = a.player.Assign(nil)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.player.Status())
+}
+
+// readCard builds a card from the request body. If it can't, an error is written and false returned.
+func (a *apiServer) readCard(w http.ResponseWriter, r *http.Request) (*sonos.CardInfo, bool) {
+	var req cardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	c, err := req.build(a.speaker)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return c, true
+}
+
+// build looks up what should go on the card. The ID of the card is left empty.
+func (req cardRequest) build(s *sonos.SonosSpeaker) (*sonos.CardInfo, error) {
+	var c *sonos.CardInfo
+	switch {
+	case req.Service != "" && req.Service != "deezer":
+		var err error
+		if c, err = sonos.FromService(req.Service, req.ServiceAlbumID, req.ServicePlaylistID, req.Title, ""); err != nil {
+			return nil, err
+		}
+	case req.AlbumID != 0:
+		a, err := deezer.GetAlbum(fmt.Sprint(req.AlbumID))
+		if err != nil {
+			return nil, err
+		}
+		c = sonos.FromAlbum(a, "")
+	case req.PlaylistID != 0:
+		p, err := deezer.GetPlaylist(fmt.Sprint(req.PlaylistID))
+		if err != nil {
+			return nil, err
+		}
+		c = sonos.FromPlaylist(p, "")
+	case req.Local != "":
+		a, err := library.GetAlbum(req.Local)
+		if err != nil {
+			return nil, err
+		}
+		c = sonos.FromLocal(a, "")
+	case req.Stream != "":
+		st, err := radio.New(req.Stream, req.Name, req.Logo)
+		if err != nil {
+			return nil, err
+		}
+		c = sonos.FromStation(st, "")
+	case req.Favorite != "":
+		f, err := s.Favorite(req.Favorite)
+		if err != nil {
+			return nil, err
+		}
+		c = sonos.FromFavorite(f, "")
+	case req.Action != "":
+		a, err := sonos.ParseAction(req.Action)
+		if err != nil {
+			return nil, err
+		}
+		c = sonos.FromAction(a, "")
+	default:
+		return nil, errors.New("one of albumId, playlistId, local, stream, service, favorite or action must be specified")
+	}
+
+	if req.Sleep != "" {
+		d, err := time.ParseDuration(req.Sleep)
+		if err != nil {
+			return nil, err
+		}
+		c.SleepTimer = d.String()
+	}
+	return c, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug("Could not write the response: ", err)
+	}
+}
+
+func writePNG(w http.ResponseWriter, name string, data []byte) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
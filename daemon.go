@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/rpc"
+	"os"
+	"time"
+)
+
+// Database is what the commands need from the database. While the player runs, it has the database and the card
+// reader to itself, so the commands ask it through the socket instead.
+type Database interface {
+	StoreCard(c *sonos.CardInfo) error
+	ReadCard(id string) (sonos.CardInfo, error)
+	ReadAll() (*[]sonos.CardInfo, error)
+	DeleteCard(id string) error
+	ReadActive() (string, error)
+	ReadPending() ([]player.PendingCard, error)
+	ReadUsage(day string) (time.Duration, error)
+	ReadRules() (player.Rules, error)
+	ReadOverride() (time.Time, error)
+	ReadHistory(limit int) ([]player.Play, error)
+	Verify() ([]string, error)
+}
+
+// Daemon is what the running player offers to other commands over the socket.
+type Daemon struct {
+	store  *DB
+	player *player.Player
+}
+
+// serveDaemon starts listening for commands on the socket. A socket that is left behind by a player that is no longer
+// running is replaced.
+func serveDaemon(path string, store *DB, p *player.Player) (net.Listener, error) {
+	if c, err := dialDaemon(path); err == nil {
+		c.Close()
+		return nil, errors.New("the player is already running")
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for commands, choose another socket with --socket: %w", err)
+	}
+	// anyone who can connect can change the cards, so only the user that runs the player may.
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	server := rpc.NewServer()
+	if err := server.Register(&Daemon{store: store, player: p}); err != nil {
+		l.Close()
+		return nil, err
+	}
+	go server.Accept(l)
+	log.Infof("Listening for commands on %v", path)
+	return l, nil
+}
+
+func (d *Daemon) StoreCard(c sonos.CardInfo, _ *bool) error {
+	if err := d.store.StoreCard(&c); err != nil {
+		return err
+	}
+	if err := d.player.CardAdded(c.ID); err != nil {
+		log.Warn("Could not tell the player about the card: ", err)
+	}
+	return nil
+}
+
+func (d *Daemon) ReadCard(id string, reply *sonos.CardInfo) error {
+	c, err := d.store.ReadCard(id)
+	*reply = c
+	return err
+}
+
+func (d *Daemon) ReadAll(_ bool, reply *[]sonos.CardInfo) error {
+	cards, err := d.store.ReadAll()
+	if err != nil {
+		return err
+	}
+	*reply = *cards
+	return nil
+}
+
+func (d *Daemon) DeleteCard(id string, _ *bool) error {
+	if err := d.store.DeleteCard(id); err != nil {
+		return err
+	}
+	if err := d.player.CardDeleted(id); err != nil {
+		log.Warn("Could not tell the player about the card: ", err)
+	}
+	return nil
+}
+
+func (d *Daemon) ReadActive(_ bool, reply *string) error {
+	id, err := d.store.ReadActive()
+	*reply = id
+	return err
+}
+
+func (d *Daemon) ReadPending(_ bool, reply *[]player.PendingCard) error {
+	cards, err := d.store.ReadPending()
+	*reply = cards
+	return err
+}
+
+func (d *Daemon) ReadUsage(day string, reply *time.Duration) error {
+	used, err := d.store.ReadUsage(day)
+	*reply = used
+	return err
+}
+
+func (d *Daemon) ReadRules(_ bool, reply *player.Rules) error {
+	r, err := d.store.ReadRules()
+	*reply = r
+	return err
+}
+
+func (d *Daemon) ReadOverride(_ bool, reply *time.Time) error {
+	until, err := d.store.ReadOverride()
+	*reply = until
+	return err
+}
+
+// SetOverride lifts the rules until the given time, or ends an override with the zero time.
+func (d *Daemon) SetOverride(until time.Time, _ *bool) error {
+	return d.store.StoreOverride(until)
+}
+
+func (d *Daemon) ReadHistory(limit int, reply *[]player.Play) error {
+	plays, err := d.store.ReadHistory(limit)
+	*reply = plays
+	return err
+}
+
+func (d *Daemon) Verify(_ bool, reply *[]string) error {
+	problems, err := d.store.Verify()
+	*reply = problems
+	return err
+}
+
+// NextCard waits for the next card that is put on the player, and replies with its ID.
+func (d *Daemon) NextCard(timeout time.Duration, reply *string) error {
+	id, err := d.player.ReadCard(timeout)
+	*reply = id
+	return err
+}
+
+// daemonClient talks to a running player.
+type daemonClient struct {
+	*rpc.Client
+}
+
+func dialDaemon(path string) (*daemonClient, error) {
+	c, err := rpc.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &daemonClient{c}, nil
+}
+
+func (c *daemonClient) StoreCard(card *sonos.CardInfo) error {
+	return c.Call("Daemon.StoreCard", *card, new(bool))
+}
+
+func (c *daemonClient) ReadCard(id string) (sonos.CardInfo, error) {
+	var card sonos.CardInfo
+	err := c.Call("Daemon.ReadCard", id, &card)
+	return card, err
+}
+
+func (c *daemonClient) ReadAll() (*[]sonos.CardInfo, error) {
+	var cards []sonos.CardInfo
+	err := c.Call("Daemon.ReadAll", false, &cards)
+	return &cards, err
+}
+
+func (c *daemonClient) DeleteCard(id string) error {
+	return c.Call("Daemon.DeleteCard", id, new(bool))
+}
+
+func (c *daemonClient) ReadActive() (string, error) {
+	var id string
+	err := c.Call("Daemon.ReadActive", false, &id)
+	return id, err
+}
+
+func (c *daemonClient) ReadPending() ([]player.PendingCard, error) {
+	var cards []player.PendingCard
+	err := c.Call("Daemon.ReadPending", false, &cards)
+	return cards, err
+}
+
+func (c *daemonClient) ReadUsage(day string) (time.Duration, error) {
+	var used time.Duration
+	err := c.Call("Daemon.ReadUsage", day, &used)
+	return used, err
+}
+
+func (c *daemonClient) ReadRules() (player.Rules, error) {
+	var r player.Rules
+	err := c.Call("Daemon.ReadRules", false, &r)
+	return r, err
+}
+
+func (c *daemonClient) ReadOverride() (time.Time, error) {
+	var until time.Time
+	err := c.Call("Daemon.ReadOverride", false, &until)
+	return until, err
+}
+
+func (c *daemonClient) SetOverride(until time.Time) error {
+	return c.Call("Daemon.SetOverride", until, new(bool))
+}
+
+func (c *daemonClient) ReadHistory(limit int) ([]player.Play, error) {
+	var plays []player.Play
+	err := c.Call("Daemon.ReadHistory", limit, &plays)
+	return plays, err
+}
+
+func (c *daemonClient) Verify() ([]string, error) {
+	var problems []string
+	err := c.Call("Daemon.Verify", false, &problems)
+	return problems, err
+}
+
+func (c *daemonClient) NextCard(timeout time.Duration) (string, error) {
+	var id string
+	err := c.Call("Daemon.NextCard", timeout, &id)
+	return id, err
+}
@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"image"
-	"io/ioutil"
-	"net/http"
 )
 
 const albumUriBase = "https://api.deezer.com/album"
@@ -51,14 +49,12 @@ func (a Album) Id() string {
 
 func GetAlbum(albumId string) (*Album, error) {
 	u := fmt.Sprintf("%s/%s", albumUriBase, albumId)
-	res, err := http.DefaultClient.Get(u)
+	body, err := get("album", u)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
 	c := new(Album)
-	body, _ := ioutil.ReadAll(res.Body)
 	if err := json.Unmarshal(body, &c); err != nil {
 		return nil, err
 	}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/fogleman/gg"
 	"github.com/nfnt/resize"
-	"github.com/sirupsen/logrus"
 	"image"
 	"io"
 	"math"
@@ -16,7 +15,8 @@ import (
 	"time"
 )
 
-var defaultArt = getDefaultArt()
+var defaultArt *image.Image
+var defaultArtOnce sync.Once
 var colors = []string{
 	"#0048BA",
 	"#D3212D",
@@ -86,7 +86,7 @@ func CreateLabelSheet(trackLists []Playable, out io.Writer) error {
 		go func(index int, p Playable) {
 			defer wg.Done()
 			c, _ := renderLabelContext(p)
-			logrus.Debugf("Rendering label for %v at index %v", p.Id(), index)
+			log.Debugf("Rendering label for %v at index %v", p.Id(), index)
 			x := baseX + (index % horizontalLabels * width)
 			y := baseY + (index / verticalLabels * height)
 
@@ -102,7 +102,7 @@ func CreateLabelSheet(trackLists []Playable, out io.Writer) error {
 	}
 	wg.Wait()
 
-	logrus.Debugln("Rendering album sheet to a PNG")
+	log.Debugln("Rendering album sheet to a PNG")
 	if err := l.EncodePNG(out); err != nil {
 		return fmt.Errorf("could not render PNG: %v", err.Error())
 	}
@@ -115,7 +115,7 @@ func CreateLabel(t Playable, out io.Writer) error {
 		return err
 	}
 
-	logrus.Debugf("Render album %v to a PNG", t.Id())
+	log.Debugf("Render album %v to a PNG", t.Id())
 	if err := l.EncodePNG(out); err != nil {
 		return fmt.Errorf("could not render PNG: %v", err.Error())
 	}
@@ -131,7 +131,7 @@ func drawCutMark(l *gg.Context, x, y int) {
 }
 
 func renderLabelContext(t Playable) (*gg.Context, error) {
-	logrus.Debugf("Generating label for %v (%v)", t.Id(), t.FullTitle())
+	log.Debugf("Generating label for %v (%v)", t.Id(), t.FullTitle())
 	img := t.CoverArt()
 
 	width := scaleI(labelWidth)
@@ -187,7 +187,8 @@ func getFrame() (image.Image, error) {
 func getDefaultArt() *image.Image {
 	img, err := loadImage("img/defaultArt.png")
 	if err != nil {
-		logrus.Error("Could not find the default album art")
+		log.Error("Could not find the default album art")
+		return nil
 	}
 	width := scaleI(img.Bounds().Dx())
 	sized := resize.Resize(uint(width), 0, img, resize.Lanczos3)
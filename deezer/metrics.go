@@ -0,0 +1,64 @@
+package deezer
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "deezer_request_seconds",
+		Help:    "How long requests to the Deezer API take.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 8),
+	}, []string{"endpoint"})
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "deezer_requests_total",
+		Help: "Requests to the Deezer API by HTTP status code, or \"error\" when no response came back.",
+	}, []string{"endpoint", "code"})
+	apiErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "deezer_api_errors_total",
+		Help: "Errors reported in the body of Deezer API responses, by Deezer error code.",
+	}, []string{"endpoint", "code"})
+)
+
+// requestTimeout is how long a request to Deezer, for the API or for cover art, may take, so that neither the player
+// nor the web UI waits on a Deezer that doesn't answer.
+const requestTimeout = 10 * time.Second
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// apiError is what Deezer answers with when something is wrong. The HTTP status code is 200 regardless.
+type apiError struct {
+	Error *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+// get fetches u from the Deezer API, and keeps track of how long it took and how it went.
+func get(endpoint, u string) ([]byte, error) {
+	start := time.Now()
+	res, err := client.Get(u)
+	if err != nil {
+		requestsTotal.WithLabelValues(endpoint, "error").Inc()
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	requestSeconds.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	requestsTotal.WithLabelValues(endpoint, strconv.Itoa(res.StatusCode)).Inc()
+	if err != nil {
+		return nil, err
+	}
+
+	var e apiError
+	if json.Unmarshal(body, &e) == nil && e.Error != nil {
+		apiErrorsTotal.WithLabelValues(endpoint, strconv.Itoa(e.Error.Code)).Inc()
+	}
+	return body, nil
+}
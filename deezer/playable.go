@@ -1,11 +1,12 @@
 package deezer
 
 import (
-	log "github.com/sirupsen/logrus"
+	"github.com/callebjorkell/rpi-nfc-player/logging"
 	"image"
-	"net/http"
 )
 
+var log = logging.For("deezer")
+
 type Playable interface {
 	String() string
 	CoverArt() *image.Image
@@ -17,19 +18,27 @@ type Playable interface {
 
 func fetchCoverArt(uri string) *image.Image {
 	if uri == "" {
-		return defaultArt
+		return DefaultCoverArt()
 	}
-	res, err := http.DefaultClient.Get(uri)
+	res, err := client.Get(uri)
 	if err != nil {
 		log.Debug(err)
-		return defaultArt
+		return DefaultCoverArt()
 	}
 	defer res.Body.Close()
 
 	img, _, err := image.Decode(res.Body)
 	if err != nil {
 		log.Debug(err)
-		return defaultArt
+		return DefaultCoverArt()
 	}
 	return &img
 }
+
+// DefaultCoverArt returns the art that is used when no cover art can be found. It's loaded the first time it's needed.
+func DefaultCoverArt() *image.Image {
+	defaultArtOnce.Do(func() {
+		defaultArt = getDefaultArt()
+	})
+	return defaultArt
+}
@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"image"
-	"io/ioutil"
-	"net/http"
 )
 
 const playlistUriBase = "https://api.deezer.com/playlist"
@@ -42,14 +40,12 @@ func (p Playlist) Id() string {
 
 func GetPlaylist(id string) (*Playlist, error) {
 	u := fmt.Sprintf("%s/%s", playlistUriBase, id)
-	res, err := http.DefaultClient.Get(u)
+	body, err := get("playlist", u)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
 	c := new(Playlist)
-	body, _ := ioutil.ReadAll(res.Body)
 	if err := json.Unmarshal(body, &c); err != nil {
 		return nil, err
 	}
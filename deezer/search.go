@@ -3,14 +3,12 @@ package deezer
 import (
 	"encoding/json"
 	"fmt"
-	log "github.com/sirupsen/logrus"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 )
 
 const (
 	searchBase = "https://api.deezer.com/search"
+	infosUri   = "https://api.deezer.com/infos"
 )
 
 type SearchContent struct {
@@ -21,6 +19,9 @@ type SearchContent struct {
 		Artist struct {
 			Name string `json:"name"`
 		} `json:"artist"`
+		// Cover is the cover art of albums, and Picture the one of playlists.
+		Cover   string `json:"cover_medium,omitempty"`
+		Picture string `json:"picture_medium,omitempty"`
 	} `json:"data"`
 	Total int `json:"total"`
 }
@@ -43,16 +44,37 @@ func Search(queryString string) (*SearchContent, error) {
 
 func search(u string) (*SearchContent, error) {
 	log.Debug("Searching on ", u)
-	res, err := http.DefaultClient.Get(u)
+	body, err := get("search", u)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
 	c := new(SearchContent)
-	body, _ := ioutil.ReadAll(res.Body)
 	if err := json.Unmarshal(body, &c); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
+
+// Ping checks that the Deezer API can be reached, and that Deezer is available where the request comes from.
+func Ping() error {
+	body, err := get("infos", infosUri)
+	if err != nil {
+		return err
+	}
+	var infos struct {
+		apiError
+		Country string `json:"country"`
+		Open    bool   `json:"open"`
+	}
+	if err := json.Unmarshal(body, &infos); err != nil {
+		return err
+	}
+	if infos.Error != nil {
+		return fmt.Errorf("the API answered with error code %v", infos.Error.Code)
+	}
+	if !infos.Open {
+		return fmt.Errorf("Deezer is not available in %v", infos.Country)
+	}
+	return nil
+}
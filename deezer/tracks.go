@@ -0,0 +1,46 @@
+package deezer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// trackPage is a page of the tracks of an album or a playlist. Next is the URL of the next page, if there is one.
+type trackPage struct {
+	Data []struct {
+		Identifier uint64 `json:"id"`
+	} `json:"data"`
+	Next string `json:"next"`
+}
+
+// GetAlbumTracks fetches the IDs of the tracks on an album, in order.
+func GetAlbumTracks(albumId string) ([]uint64, error) {
+	return getTracks("album", fmt.Sprintf("%s/%s/tracks", albumUriBase, albumId))
+}
+
+// GetPlaylistTracks fetches the IDs of the tracks in a playlist, in order.
+func GetPlaylistTracks(id string) ([]uint64, error) {
+	return getTracks("playlist", fmt.Sprintf("%s/%s/tracks", playlistUriBase, id))
+}
+
+func getTracks(endpoint, first string) ([]uint64, error) {
+	var ids []uint64
+	for u := first; u != ""; {
+		body, err := get(endpoint+"_tracks", u)
+		if err != nil {
+			return nil, err
+		}
+		var page trackPage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, t := range page.Data {
+			ids = append(ids, t.Identifier)
+		}
+		u = page.Next
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no tracks found at %v", first)
+	}
+	return ids, nil
+}
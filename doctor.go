@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/callebjorkell/rpi-nfc-player/nfc"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"github.com/callebjorkell/rpi-nfc-player/ui"
+	"os"
+	"strings"
+)
+
+// errSkipped marks a check that could not be run, which is not a failure.
+var errSkipped = errors.New("skipped")
+
+// diagnosis is the outcome of a single check of the doctor.
+type diagnosis struct {
+	detail string
+	err    error
+	// hint tells what to do about a failed check.
+	hint string
+}
+
+type doctorCheck struct {
+	name string
+	run  func() diagnosis
+}
+
+// runDoctor checks everything the player needs, prints a report, and exits with an error if anything failed.
+func runDoctor(speaker string) {
+	checks := []doctorCheck{
+		{"Database", checkDatabase},
+		{"Deezer", checkDeezer},
+		{"Speaker", func() diagnosis { return checkSpeaker(speaker) }},
+		{"Card reader", checkReader},
+		{"Buttons, LED and tiger", checkHardware},
+	}
+
+	failed := 0
+	for _, c := range checks {
+		d := c.run()
+		result := "PASS"
+		switch {
+		case errors.Is(d.err, errSkipped):
+			result = "SKIP"
+		case d.err != nil:
+			result = "FAIL"
+			failed++
+			d.detail = d.err.Error()
+		}
+		fmt.Printf("%v  %-24s %v\n", result, c.name, d.detail)
+		if result == "FAIL" && d.hint != "" {
+			fmt.Printf("      %-24s %v\n", "", d.hint)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%v of %v checks failed.\n", failed, len(checks))
+		os.Exit(1)
+	}
+}
+
+func skipped(reason string) diagnosis {
+	return diagnosis{detail: reason, err: errSkipped}
+}
+
+func checkDatabase() diagnosis {
+	hint := fmt.Sprintf("Restore %v from a backup, or remove the broken cards with the remove command.", dbFile)
+	store := db
+	if daemon == nil {
+		s, err := OpenDB()
+		if err != nil {
+			hint := fmt.Sprintf("Check that %v is in the working directory, and that it can be written to.", dbFile)
+			return diagnosis{err: err, hint: hint}
+		}
+		defer s.Close()
+		store = s
+	}
+
+	problems, err := store.Verify()
+	if err != nil {
+		return diagnosis{err: err, hint: hint}
+	}
+	if len(problems) > 0 {
+		err := fmt.Errorf("%v broken records: %v", len(problems), strings.Join(problems, "; "))
+		return diagnosis{err: err, hint: hint}
+	}
+	cards, err := store.ReadAll()
+	if err != nil {
+		return diagnosis{err: err, hint: hint}
+	}
+	detail := fmt.Sprintf("%v cards, all records parse", len(*cards))
+	if daemon != nil {
+		detail += " (read through the running player)"
+	}
+	return diagnosis{detail: detail}
+}
+
+func checkDeezer() diagnosis {
+	if err := deezer.Ping(); err != nil {
+		return diagnosis{err: err, hint: "Check that the Pi is connected to the internet, and that it can resolve " +
+			"api.deezer.com."}
+	}
+	return diagnosis{detail: "the API answers"}
+}
+
+func checkSpeaker(name string) diagnosis {
+	if name == "" {
+		return skipped("give the name of the speaker with --speaker")
+	}
+	s, err := sonos.New(name)
+	if err != nil {
+		return diagnosis{err: err, hint: "Check that the speaker is on, that the Pi is on the same network (SSDP needs " +
+			"multicast), and that the name matches the room in the Sonos app."}
+	}
+	return diagnosis{detail: fmt.Sprintf("found %v, and it answers GetZoneAttributes", s.Name())}
+}
+
+func checkReader() diagnosis {
+	if daemon != nil {
+		return skipped("the running player is using the reader")
+	}
+	version, err := nfc.CheckReader()
+	if errors.Is(err, nfc.ErrNoHardware) {
+		return skipped(err.Error())
+	}
+	if err != nil {
+		return diagnosis{err: err, hint: "Check that SPI is enabled (raspi-config), and the wiring of the RC522, " +
+			"including the reset pin on GPIO22."}
+	}
+	return diagnosis{detail: fmt.Sprintf("RC522 answers with version %#02x", version)}
+}
+
+func checkHardware() diagnosis {
+	if daemon != nil {
+		return skipped("the running player is using the pins")
+	}
+	err := ui.CheckHardware()
+	if errors.Is(err, ui.ErrNoHardware) {
+		return skipped(err.Error())
+	}
+	if err != nil {
+		return diagnosis{err: err, hint: "Check that nothing else is using the GPIO pins, and that the player runs " +
+			"as a user that can access them (like root, or a member of the gpio group)."}
+	}
+	return diagnosis{detail: "pins claimed, the LED showed red, green and blue, and the tiger came out"}
+}
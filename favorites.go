@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+func listFavorites(speaker string) {
+	s, err := sonos.New(speaker)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	f, err := s.Favorites()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if len(f) == 0 {
+		fmt.Println("No favorites or saved playlists found on the speaker.")
+		return
+	}
+	fmt.Println("            ID │ Type     │ Title")
+	fmt.Println("───────────────┼──────────┼──────────────────────────")
+	for _, v := range f {
+		kind := "favorite"
+		if strings.HasPrefix(v.ID, "SQ:") {
+			kind = "playlist"
+		} else if !v.Queueable() {
+			kind = "stream"
+		}
+		fmt.Printf("%14v │ %8v │ %v\n", v.ID, kind, checkLength(v.Title, 75))
+	}
+}
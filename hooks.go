@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// runHooks runs the configured executable for every event of the player that has one. Every hook runs on its own, and
+// is killed when it runs for longer than the timeout, so that a slow hook never holds up the player.
+func runHooks(hooks map[string]string, timeout time.Duration, p *player.Player) error {
+	for name := range hooks {
+		if !knownEvent(name) {
+			return fmt.Errorf("unknown hook event %v, should be one of %v", name, player.EventTypes)
+		}
+	}
+
+	events := p.Events()
+	go func() {
+		for e := range events {
+			if path, ok := hooks[string(e.Type)]; ok {
+				go runHook(path, timeout, e)
+			}
+		}
+	}()
+	log.Infof("Running hooks for %v events", len(hooks))
+	return nil
+}
+
+func knownEvent(name string) bool {
+	for _, t := range player.EventTypes {
+		if string(t) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runHook runs a single hook. The event is given as JSON on stdin, and in NFC_PLAYER_* environment variables.
+func runHook(path string, timeout time.Duration, e player.Event) {
+	input, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Could not encode the %v event: %v", e.Type, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	// children of the hook may hold on to its output after it has been killed.
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"NFC_PLAYER_EVENT="+string(e.Type),
+		"NFC_PLAYER_TIME="+e.Time.Format(time.RFC3339),
+		"NFC_PLAYER_STATE="+e.State.String(),
+		"NFC_PLAYER_CARD="+e.Card,
+		"NFC_PLAYER_TITLE="+e.Title,
+		"NFC_PLAYER_BUTTON="+e.Button,
+		"NFC_PLAYER_ERROR="+e.Error,
+	)
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warnf("Hook %v for %v was killed after %v", path, e.Type, timeout)
+		return
+	}
+	if err != nil {
+		if output := strings.TrimSpace(string(out)); output != "" {
+			err = fmt.Errorf("%w: %v", err, output)
+		}
+		log.Warnf("Hook %v for %v failed: %v", path, e.Type, err)
+		return
+	}
+	log.Debugf("Hook %v for %v finished in %v", path, e.Type, time.Since(start))
+}
@@ -0,0 +1,162 @@
+package library
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/dhowden/tag"
+	log "github.com/sirupsen/logrus"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Root is the directory that local albums are read from. Album paths are always relative to this directory.
+var Root = "library"
+
+var mimeTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".m4a":  "audio/mp4",
+}
+
+type Track struct {
+	// Path of the audio file, relative to the library root.
+	Path     string
+	Title    string
+	Artist   string
+	Album    string
+	Disc     int
+	Number   int
+	MimeType string
+}
+
+type Album struct {
+	// Path of the album directory, relative to the library root.
+	Path   string
+	Tracks []Track
+	cover  []byte
+}
+
+func (a Album) Title() string {
+	for _, t := range a.Tracks {
+		if t.Album != "" {
+			return t.Album
+		}
+	}
+	return path.Base(a.Path)
+}
+
+func (a Album) Artist() string {
+	for _, t := range a.Tracks {
+		if t.Artist != "" {
+			return t.Artist
+		}
+	}
+	return ""
+}
+
+func (a Album) FullTitle() string {
+	if a.Artist() == "" {
+		return a.Title()
+	}
+	return fmt.Sprintf("%v - %v", a.Artist(), a.Title())
+}
+
+func (a Album) String() string {
+	return fmt.Sprintf("Type: local, path: %v, artist: %v, title: %v, tracks: %v", a.Path, a.Artist(), a.Title(), len(a.Tracks))
+}
+
+func (a Album) CoverArt() *image.Image {
+	if len(a.cover) == 0 {
+		return deezer.DefaultCoverArt()
+	}
+	img, _, err := image.Decode(bytes.NewReader(a.cover))
+	if err != nil {
+		log.Debug(err)
+		return deezer.DefaultCoverArt()
+	}
+	return &img
+}
+
+func (a Album) Id() string {
+	return fmt.Sprintf("l-%v", strings.ReplaceAll(a.Path, "/", "_"))
+}
+
+// GetAlbum reads all the audio files in the given directory, and orders them by disc and track number.
+func GetAlbum(albumPath string) (*Album, error) {
+	albumPath = path.Clean("/" + filepath.ToSlash(albumPath))[1:]
+	dir := filepath.Join(Root, filepath.FromSlash(albumPath))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Album{Path: albumPath}
+	for _, e := range entries {
+		mimeType, ok := mimeTypes[strings.ToLower(filepath.Ext(e.Name()))]
+		if e.IsDir() || !ok {
+			continue
+		}
+		t := Track{
+			Path:     path.Join(albumPath, e.Name()),
+			Title:    strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())),
+			MimeType: mimeType,
+		}
+		if err := readTags(filepath.Join(dir, e.Name()), &t, a); err != nil {
+			log.Debugf("Could not read tags from %v: %v", t.Path, err)
+		}
+		a.Tracks = append(a.Tracks, t)
+	}
+
+	if len(a.Tracks) == 0 {
+		return a, fmt.Errorf("no audio files found in %v", dir)
+	}
+
+	sort.SliceStable(a.Tracks, func(i, j int) bool {
+		if a.Tracks[i].Disc != a.Tracks[j].Disc {
+			return a.Tracks[i].Disc < a.Tracks[j].Disc
+		}
+		if a.Tracks[i].Number != a.Tracks[j].Number {
+			return a.Tracks[i].Number < a.Tracks[j].Number
+		}
+		return a.Tracks[i].Path < a.Tracks[j].Path
+	})
+	return a, nil
+}
+
+func readTags(file string, t *Track, a *Album) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		return err
+	}
+
+	if m.Title() != "" {
+		t.Title = m.Title()
+	}
+	t.Album = m.Album()
+	t.Artist = m.AlbumArtist()
+	if t.Artist == "" {
+		t.Artist = m.Artist()
+	}
+	t.Number, _ = m.Track()
+	t.Disc, _ = m.Disc()
+
+	if p := m.Picture(); p != nil && len(a.cover) == 0 {
+		a.cover = p.Data
+	}
+	return nil
+}
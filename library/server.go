@@ -0,0 +1,77 @@
+package library
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const pathPrefix = "/library/"
+
+// Server makes the files in the library available over HTTP, so that they can be played by the speaker.
+type Server struct {
+	listener net.Listener
+	host     string
+}
+
+// Serve starts serving the library root on the given address. If no host is part of the address, the address of
+// the interface used for reaching the local network is used when constructing URLs.
+func Serve(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	if host == "" {
+		host, err = localIP()
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("could not determine the local address: %v", err)
+		}
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	mux := http.NewServeMux()
+	mux.Handle(pathPrefix, http.StripPrefix(pathPrefix, http.FileServer(http.Dir(Root))))
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Warn("Library server stopped: ", err)
+		}
+	}()
+
+	s := &Server{listener: l, host: net.JoinHostPort(host, port)}
+	log.Infof("Serving the local library from %v on http://%v%v", Root, s.host, pathPrefix)
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// URL returns the address that the speaker can fetch the given library path from.
+func (s *Server) URL(trackPath string) string {
+	segments := strings.Split(trackPath, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return fmt.Sprintf("http://%v%v%v", s.host, pathPrefix, strings.Join(segments, "/"))
+}
+
+func localIP() (string, error) {
+	// no packets are sent for UDP, but it makes the kernel pick the interface that would be used for the SSDP
+	// multicast, which is the network where the speakers live.
+	c, err := net.Dial("udp", "239.255.255.250:1900")
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// journalHook sends log lines to the systemd journal. The fields of the line become journal fields, so that the
+// journal can be filtered on them, like with journalctl SUBSYSTEM=nfc.
+type journalHook struct {
+	identifier string
+}
+
+func newJournalHook() (*journalHook, error) {
+	if !journal.Enabled() {
+		return nil, errors.New("the systemd journal is not available")
+	}
+	return &journalHook{identifier: filepath.Base(os.Args[0])}, nil
+}
+
+func (h *journalHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *journalHook) Fire(e *logrus.Entry) error {
+	vars := map[string]string{"SYSLOG_IDENTIFIER": h.identifier}
+	for k, v := range e.Data {
+		vars[journalField(k)] = fmt.Sprint(v)
+	}
+	return journal.Send(e.Message, journalPriority(e.Level), vars)
+}
+
+// journalField turns a field name into one that the journal accepts, which is upper case letters, digits and
+// underscores, not starting with an underscore or a digit.
+func journalField(name string) string {
+	field := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	field = strings.TrimLeft(field, "_")
+	if field == "" || field[0] >= '0' && field[0] <= '9' {
+		field = "F_" + field
+	}
+	return field
+}
+
+func journalPriority(level logrus.Level) journal.Priority {
+	switch level {
+	case logrus.PanicLevel:
+		return journal.PriEmerg
+	case logrus.FatalLevel:
+		return journal.PriCrit
+	case logrus.ErrorLevel:
+		return journal.PriErr
+	case logrus.WarnLevel:
+		return journal.PriWarning
+	case logrus.InfoLevel:
+		return journal.PriInfo
+	}
+	return journal.PriDebug
+}
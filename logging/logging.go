@@ -0,0 +1,135 @@
+// Package logging sets up where the logs of the player go, and how much of them. Every subsystem has its own logger, so
+// that its level can be set on its own, and every line it logs carries the name of the subsystem.
+package logging
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const timestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Formats of the log lines.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// subsystemField is the field that carries the name of the subsystem.
+const subsystemField = "subsystem"
+
+var loggers = make(map[string]*logrus.Logger)
+
+// Config holds the logging settings.
+type Config struct {
+	// Format is either FormatText or FormatJSON.
+	Format string
+	// Level is the level of everything that has no level of its own in Levels.
+	Level logrus.Level
+	// Levels are the levels of the subsystems, by name.
+	Levels map[string]logrus.Level
+	// File is where the logs are written, instead of to standard error. It's rotated once it's MaxSize megabytes,
+	// and MaxFiles old files are kept.
+	File     string
+	MaxSize  int
+	MaxFiles int
+	// Journald sends the logs to the systemd journal, with the fields of each line as journal fields.
+	Journald bool
+}
+
+// For returns the logger of a subsystem. It's meant to be called when the package of the subsystem is initialized, and
+// follows the config that is given to Configure later.
+func For(subsystem string) *logrus.Entry {
+	l, ok := loggers[subsystem]
+	if !ok {
+		l = logrus.New()
+		l.SetFormatter(logrus.StandardLogger().Formatter)
+		loggers[subsystem] = l
+	}
+	return l.WithField(subsystemField, subsystem)
+}
+
+// Subsystems are the names of the subsystems that have loggers.
+func Subsystems() []string {
+	names := make([]string, 0, len(loggers))
+	for name := range loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseLevels parses subsystem levels, given as subsystem names and level names.
+func ParseLevels(levels map[string]string) (map[string]logrus.Level, error) {
+	parsed := make(map[string]logrus.Level)
+	for name, level := range levels {
+		if _, ok := loggers[name]; !ok {
+			return nil, fmt.Errorf("unknown subsystem %v, should be one of %v", name, strings.Join(Subsystems(), ", "))
+		}
+		l, err := logrus.ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = l
+	}
+	return parsed, nil
+}
+
+// Configure sets up the loggers of all subsystems, and the standard logger of logrus, which is used for everything
+// that isn't part of a subsystem.
+func Configure(c Config) error {
+	var formatter logrus.Formatter
+	switch c.Format {
+	case FormatText, "":
+		formatter = &logrus.TextFormatter{TimestampFormat: timestampFormat}
+	case FormatJSON:
+		formatter = &logrus.JSONFormatter{TimestampFormat: timestampFormat}
+	default:
+		return fmt.Errorf("unknown log format %v", c.Format)
+	}
+
+	var out io.Writer = os.Stderr
+	if c.File != "" {
+		out = &lumberjack.Logger{
+			Filename:   c.File,
+			MaxSize:    c.MaxSize,
+			MaxBackups: c.MaxFiles,
+		}
+	} else if c.Journald {
+		// everything goes to the journal already, and standard error of a service usually ends up there too.
+		out = io.Discard
+	}
+
+	var hooks []logrus.Hook
+	if c.Journald {
+		hook, err := newJournalHook()
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	setup := func(l *logrus.Logger, level logrus.Level) {
+		l.SetFormatter(formatter)
+		l.SetOutput(out)
+		l.SetLevel(level)
+		l.ReplaceHooks(make(logrus.LevelHooks))
+		for _, h := range hooks {
+			l.AddHook(h)
+		}
+	}
+	setup(logrus.StandardLogger(), c.Level)
+	for name, l := range loggers {
+		level, ok := c.Levels[name]
+		if !ok {
+			level = c.Level
+		}
+		setup(l, level)
+	}
+	return nil
+}
@@ -4,22 +4,35 @@ import (
 	"errors"
 	"fmt"
 	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/callebjorkell/rpi-nfc-player/library"
+	"github.com/callebjorkell/rpi-nfc-player/logging"
 	"github.com/callebjorkell/rpi-nfc-player/nfc"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
 	sonos "github.com/callebjorkell/rpi-nfc-player/sonos"
 	"github.com/callebjorkell/rpi-nfc-player/ui"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"os"
 	"os/signal"
-	"strconv"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 )
 
-var tigerArmed = false
-var playing = false
-var db = GetDB()
+// speakerPollInterval is how often the player checks what the speaker is doing.
+const speakerPollInterval = 2 * time.Second
+
+// db is the database, or the running player when there is one.
+var db Database
+
+// daemon is the running player, if there is one.
+var daemon *daemonClient
+
+// defaultSocket is where the running player listens for commands, unless it's told otherwise. It's the same for the
+// service and for the commands that are run from a shell, and its directory is created by systemd with
+// RuntimeDirectory=rpi-nfc-player.
+const defaultSocket = "/run/rpi-nfc-player/rpi-nfc-player.sock"
 
 type idList []string
 
@@ -43,17 +56,59 @@ func IDList(s kingpin.Settings) (target *[]string) {
 }
 
 var (
-	app     = kingpin.New("rpi-nfc-player", "Music player that plays Deezer albums on a Sonos speaker with the help of NFC cards, a Raspberry Pi and some buttons.")
-	debug   = app.Flag("debug", "Turn on debug logging.").Bool()
-	start   = app.Command("start", "Start the music player and start listening for NFC cards.")
-	speaker = start.Flag("speaker", "The name of the speaker that the player should control.").Required().String()
+	app          = kingpin.New("rpi-nfc-player", "Music player that plays Deezer albums on a Sonos speaker with the help of NFC cards, a Raspberry Pi and some buttons.")
+	debug        = app.Flag("debug", "Turn on debug logging.").Bool()
+	logLevel     = app.Flag("log-level", "The level of logging, for everything that has no level of its own.").Default("info").Enum("trace", "debug", "info", "warning", "error")
+	logLevels    = app.Flag("log", "The level of logging of a subsystem, as SUBSYSTEM=LEVEL. Can specify multiple.").StringMap()
+	logFormat    = app.Flag("log-format", "The format of the log lines, text or json.").Default(logging.FormatText).Enum(logging.FormatText, logging.FormatJSON)
+	logFile      = app.Flag("log-file", "Write the logs to this file instead of standard error.").String()
+	logMaxSize   = app.Flag("log-max-size", "The size in megabytes at which the log file is rotated.").Default("10").Int()
+	logMaxFiles  = app.Flag("log-max-files", "How many rotated log files to keep.").Default("3").Int()
+	journald     = app.Flag("journald", "Send the logs to the systemd journal, with the subsystem and other fields as journal fields.").Bool()
+	libraryDir   = app.Flag("library", "The directory that local albums are read from.").Default("library").String()
+	accounts     = app.Flag("account", "Account token to use for a music service, as SERVICE=TOKEN. Can specify multiple.").StringMap()
+	socketPath   = app.Flag("socket", "The socket that the running player listens for commands on.").Default(defaultSocket).String()
+	mockReader   = app.Flag("mock-reader", "Where the card reader takes its commands from when not built for the Raspberry Pi: - for standard input, unix:PATH for a socket, or a scenario file.").Default("-").String()
+	start        = app.Command("start", "Start the music player and start listening for NFC cards.")
+	speaker      = start.Flag("speaker", "The name of the speaker that the player should control.").Required().String()
+	libraryAddr  = start.Flag("library-addr", "The address that local albums are served to the speaker from.").Default(":8091").String()
+	bedtime      = start.Flag("bedtime", "Time of day (HH:MM) after which a sleep timer is applied to anything that plays.").String()
+	bedtimeSleep = start.Flag("bedtime-sleep", "The sleep timer that is applied after bedtime.").Default("20m").Duration()
+	sleepFade    = start.Flag("sleep-fade", "How long the volume is faded out before a sleep timer stops the music.").Default("30s").Duration()
+	grace        = start.Flag("grace", "How long a card may be away from the lid before the music stops.").Default("0s").Duration()
+	quietHours   = start.Flag("quiet-hours", "Time span (HH:MM-HH:MM) during which no cards are played.").String()
+	dailyLimit   = start.Flag("daily-limit", "How long the player may play each day, like 90m.").Duration()
+	httpAddr     = start.Flag("http", "Serve the HTTP API on this address, like :8080.").String()
+	httpToken    = start.Flag("http-token", "The token that requests to the HTTP API must carry.").Envar("NFC_PLAYER_HTTP_TOKEN").String()
+	metricsAddr  = start.Flag("metrics", "Serve Prometheus metrics on this address, like :9100.").String()
+	hooks        = start.Flag("hook", "Run an executable when something happens, as EVENT=PATH. Can specify multiple.").StringMap()
+	hookTimeout  = start.Flag("hook-timeout", "How long a hook may run before it is killed.").Default("10s").Duration()
+	stations     = start.Flag("station", "A radio station that the buttons cycle through while radio plays, as NAME=URL. Can specify multiple, in order.").Strings()
+	mqttBroker   = start.Flag("mqtt", "Publish the player on this MQTT broker, like tcp://localhost:1883.").String()
+	mqttUser     = start.Flag("mqtt-user", "The user to log in to the MQTT broker with.").String()
+	mqttPassword = start.Flag("mqtt-password", "The password to log in to the MQTT broker with.").Envar("NFC_PLAYER_MQTT_PASSWORD").String()
+	mqttTopic    = start.Flag("mqtt-topic", "The topic that the player is published under.").Default("tigerplayer").String()
+	mqttDiscover = start.Flag("mqtt-discovery", "The Home Assistant discovery prefix. Empty turns off discovery.").Default("homeassistant").String()
 
 	check         = app.Command("check", "Check all album/playlist entries and show problems.")
 	checkRefresh  = check.Flag("refresh", "Re-write the information into the database. Useful if the data format has changed.").Bool()
 	add           = app.Command("add", "Construct and add a new playlist to a card.")
 	addAlbumId    = add.Flag("albumId", "The ID of the album that should be added.").Uint64()
 	addPlaylistId = add.Flag("playlistId", "The ID of the playlist that should be added.").Uint64()
+	addLocal      = add.Flag("local", "The path of a local album directory that should be added, relative to the library directory.").String()
+	addStream     = add.Flag("stream", "The URL of an internet radio stream that should be added.").String()
+	addName       = add.Flag("name", "The name of the radio station when adding a stream.").String()
+	addLogo       = add.Flag("logo", "Path or URL to a logo image that is used on the label when adding a stream.").String()
 	addCardId     = add.Flag("cardId", "Manually specify the card id to be used.").String()
+	addService    = add.Flag("service", "The music service that the album or playlist should be played from.").Default("deezer").Enum(sonos.ServiceNames()...)
+	addServiceAId = add.Flag("serviceAlbumId", "The ID of the album to add, for services other than Deezer.").String()
+	addServicePId = add.Flag("servicePlaylistId", "The ID of the playlist to add, for services other than Deezer.").String()
+	addAction     = add.Flag("action", "Make a control card, as TYPE[:VALUE]. One of shuffle:on|off, volume:-5|+5, reset, sleep:15m or speaker:NAME.").String()
+	addSleep      = add.Flag("sleep", "Stop playing after this long every time the card is put on, like 30m.").Duration()
+	addTitle      = add.Flag("title", "The title to show for the card, for services other than Deezer.").String()
+	addFavorite   = add.Flag("favorite", "The ID of a Sonos favorite or saved Sonos playlist that should be added. Requires --speaker.").String()
+	addSpeaker    = add.Flag("speaker", "The name of the speaker to look up favorites on.").String()
+	addPending    = add.Flag("pending", "Add to the unknown card that was most recently put on the running player.").Bool()
 
 	remove       = app.Command("remove", "Remove a card from the database.")
 	removeCardId = remove.Flag("cardId", "Manually specify the card id to be used.").String()
@@ -63,6 +118,18 @@ var (
 	dumpInfo   = dump.Flag("info", "Dump information about the album/playlist the card points to instead of the data on the card.").Bool()
 	dumpList   = dump.Flag("list", "Dump a short list of all the cards in the database").Bool()
 
+	favorites        = app.Command("favorites", "List the Sonos favorites and saved Sonos playlists that can be put on cards.")
+	favoritesSpeaker = favorites.Flag("speaker", "The name of the speaker to list favorites for.").Required().String()
+
+	status        = app.Command("status", "Show what the speaker is playing, and which card it came from.")
+	statusSpeaker = status.Flag("speaker", "The name of the speaker to show the status for.").Required().String()
+	statusQueue   = status.Flag("queue", "List all the tracks in the queue as well.").Bool()
+
+	pending = app.Command("pending", "List the unknown cards that have been put on the player lately.")
+
+	rulesCmd      = app.Command("rules", "Show the listening rules and today's usage.")
+	rulesOverride = rulesCmd.Flag("override", "Lift the rules for this long, like 1h. Use 0s to end an override.").String()
+
 	search       = app.Command("search", "Search for albums on deezer")
 	searchString = search.Arg("query", "The string to search on.").Required().String()
 
@@ -72,7 +139,10 @@ var (
 	labelCardId     = IDList(label.Flag("cardId", "Manually specify the card(s) that the label should be printed for. Can specify multiple."))
 	sheet           = label.Flag("sheet", "Render all labels in the database onto A4 sized sheets for batch printing. Using this ignores the id flags if set.").Bool()
 
-	version         = app.Command("version", "Show current version.")
+	doctor        = app.Command("doctor", "Check that everything the player needs works, with hints on how to fix what doesn't.")
+	doctorSpeaker = doctor.Flag("speaker", "The name of the speaker that the player should control.").String()
+
+	version = app.Command("version", "Show current version.")
 )
 
 func main() {
@@ -82,7 +152,8 @@ func main() {
 	go func() {
 		select {
 		case <-signalChan:
-			os.Exit(0)
+			// exits through logrus, so that its exit handlers can put the terminal back in order.
+			log.Exit(0)
 		}
 	}()
 
@@ -92,24 +163,70 @@ func main() {
 		os.Exit(1)
 	}
 
-	log.SetFormatter(&log.TextFormatter{
-		TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
-	})
-	if *debug {
-		log.Info("Enabling debug output...")
-		log.SetLevel(log.DebugLevel)
+	if err := configureLogging(); err != nil {
+		kingpin.FatalUsage(err.Error())
+	}
+	library.Root = *libraryDir
+	nfc.MockSource = *mockReader
+	for service, token := range *accounts {
+		if err := sonos.SetAccountToken(service, token); err != nil {
+			kingpin.FatalUsage(err.Error())
+		}
+	}
+
+	if cmd != start.FullCommand() {
+		if c, err := dialDaemon(*socketPath); err == nil {
+			log.Debug("Talking to the running player")
+			daemon = c
+			db = c
+			defer c.Close()
+		} else if _, statErr := os.Stat(*socketPath); statErr == nil {
+			// the database is the running player's, so it's not opened next to it.
+			log.Fatalf("There is a player on %v, but it can't be reached (%v). If no player is running, remove the "+
+				"socket.", *socketPath, err)
+		} else if cmd != doctor.FullCommand() {
+			// the doctor opens the database itself, to be able to tell what is wrong with it.
+			db = GetDB()
+		}
 	}
 
 	switch cmd {
 	case start.FullCommand():
 		startServer()
 	case add.FullCommand():
-		if *addAlbumId != 0 {
+		if *addService == "deezer" && (*addServiceAId != "" || *addServicePId != "") {
+			kingpin.FatalUsage("serviceAlbumId and servicePlaylistId are for other services, Deezer takes albumId " +
+				"or playlistId")
+		}
+		if *addPending {
+			if *addCardId != "" {
+				kingpin.FatalUsage("Only one of cardId and pending can be specified")
+			}
+			id, err := latestPending()
+			if err != nil {
+				log.Fatal(err)
+			}
+			*addCardId = id
+		}
+		if *addService != "deezer" {
+			storeServiceItem(*addService, *addServiceAId, *addServicePId, *addTitle, *addCardId)
+		} else if *addAlbumId != 0 {
 			storeAlbum(*addAlbumId, *addCardId)
 		} else if *addPlaylistId != 0 {
 			storePlaylist(*addPlaylistId, *addCardId)
+		} else if *addLocal != "" {
+			storeLocal(*addLocal, *addCardId)
+		} else if *addStream != "" {
+			storeStream(*addStream, *addName, *addLogo, *addCardId)
+		} else if *addFavorite != "" {
+			if *addSpeaker == "" {
+				kingpin.FatalUsage("A speaker must be specified for adding favorites")
+			}
+			storeFavorite(*addFavorite, *addSpeaker, *addCardId)
+		} else if *addAction != "" {
+			storeAction(*addAction, *addCardId)
 		} else {
-			kingpin.FatalUsage("One of albumid or playlistid must be specified")
+			kingpin.FatalUsage("One of albumid, playlistid, local, stream, favorite or action must be specified")
 		}
 	case remove.FullCommand():
 		removeCard(*removeCardId)
@@ -119,12 +236,22 @@ func main() {
 		} else {
 			dumpCard(*dumpCardId)
 		}
+	case favorites.FullCommand():
+		listFavorites(*favoritesSpeaker)
+	case status.FullCommand():
+		showStatus(*statusSpeaker, *statusQueue)
+	case pending.FullCommand():
+		listPending()
+	case rulesCmd.FullCommand():
+		showRules(*rulesOverride)
 	case search.FullCommand():
 		searchAlbum()
 	case label.FullCommand():
 		createLabel()
 	case check.FullCommand():
 		checkEntries()
+	case doctor.FullCommand():
+		runDoctor(*doctorSpeaker)
 	case version.FullCommand():
 		showVersion()
 	default:
@@ -132,6 +259,33 @@ func main() {
 	}
 }
 
+func configureLogging() error {
+	levels, err := logging.ParseLevels(*logLevels)
+	if err != nil {
+		return err
+	}
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		return err
+	}
+	if *debug {
+		level = log.DebugLevel
+	}
+	err = logging.Configure(logging.Config{
+		Format:   *logFormat,
+		Level:    level,
+		Levels:   levels,
+		File:     *logFile,
+		MaxSize:  *logMaxSize,
+		MaxFiles: *logMaxFiles,
+		Journald: *journald,
+	})
+	if err == nil && *debug {
+		log.Info("Enabling debug output...")
+	}
+	return err
+}
+
 var buildTime, buildVersion string
 
 func showVersion() {
@@ -143,6 +297,12 @@ func showVersion() {
 }
 
 func readSingleCard() (string, error) {
+	if daemon != nil {
+		// the player has the reader, so it has to read the card.
+		fmt.Println("Please put a card on the player...")
+		return daemon.NextCard(20 * time.Second)
+	}
+
 	c, err := nfc.CreateReader()
 	if err != nil {
 		log.Fatal(err)
@@ -176,7 +336,14 @@ func checkEntries() {
 	}
 
 	for _, e := range *entries {
-		if e.AlbumID != nil {
+		if e.ServiceName() != "deezer" {
+			// there is no API to check these against, so just make sure that there is something to play
+			if e.AlbumIDString() == "" && e.PlaylistIDString() == "" {
+				fmt.Printf("FAIL %15s (%v - %v): Has no album/playlist ID.\n", e.ID, e.ServiceName(), e.Title)
+			} else {
+				fmt.Printf("OK   %15s (%v %v%v - %v)\n", e.ID, e.ServiceName(), e.AlbumIDString(), e.PlaylistIDString(), e.Title)
+			}
+		} else if e.AlbumID != nil {
 			a, err := deezer.GetAlbum(e.AlbumIDString())
 			if err != nil {
 				fmt.Printf("FAIL %15s (album %v - %v): %v\n", e.ID, e.AlbumIDString(), e.Title, err)
@@ -204,6 +371,26 @@ func checkEntries() {
 					panic(err)
 				}
 			}
+		} else if e.LocalPath != "" {
+			a, err := library.GetAlbum(e.LocalPath)
+			if err != nil {
+				fmt.Printf("FAIL %15s (local %v - %v): %v\n", e.ID, e.LocalPath, e.Title, err)
+			} else {
+				fmt.Printf("OK   %15s (local %v - %v)\n", e.ID, e.LocalPath, e.Title)
+			}
+			if *checkRefresh && err == nil {
+				c := sonos.FromLocal(a, e.ID)
+				err := db.StoreCard(c)
+				if err != nil {
+					panic(err)
+				}
+			}
+		} else if e.Stream != nil {
+			if err := e.Stream.Check(); err != nil {
+				fmt.Printf("FAIL %15s (stream %v - %v): %v\n", e.ID, e.Stream.URL, e.Title, err)
+			} else {
+				fmt.Printf("OK   %15s (stream %v - %v)\n", e.ID, e.Stream.URL, e.Title)
+			}
 		} else {
 			fmt.Printf("FAIL %15s: Has no album/playlist ID.\n", e.ID)
 		}
@@ -213,157 +400,100 @@ func checkEntries() {
 }
 
 func startServer() {
+	store := GetDB()
+	db = store
+
+	if *metricsAddr != "" {
+		m, err := serveMetrics(*metricsAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer m.Close()
+	}
+
 	s, err := sonos.New(*speaker)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	tiger := ui.InitTiger()
-	buttons := ui.InitButtons()
-	led := ui.GetColorLED()
-
-	checkTiger := tigerCheck(tiger, led)
-	checkTiger()
-	playing = false
-	playSync := &sync.Mutex{}
-
-	go func() {
-		for {
-			select {
-			case b, ok := <-buttons:
-				if !ok {
-					log.Error("Button channel has closed. Stopping event loop.")
-					return
-				}
-
-				playSync.Lock()
-				handleButton(&b, playing, tiger, led, s)
-				playSync.Unlock()
-			case <-time.After(time.Second):
-				// allow the scheduler to run
-			}
-		}
-	}()
-
-	reader, err := nfc.CreateReader()
+	l, err := library.Serve(*libraryAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer reader.Close()
-	lastActive := ""
-	for {
-		card, open := <-reader.Events()
-		if !open {
-			return
+	defer l.Close()
+	s.UseLibrary(l)
+
+	cfg := player.Config{
+		Grace:        *grace,
+		Bedtime:      -1,
+		BedtimeSleep: *bedtimeSleep,
+		SleepFade:    *sleepFade,
+	}
+	for _, st := range *stations {
+		name, u, ok := strings.Cut(st, "=")
+		if !ok {
+			log.Fatalf("Invalid station %q, it should be NAME=URL", st)
 		}
-
-		playSync.Lock()
-		// used to track weather the tiger should be activated or not.
-		playing = isPlaying(&card)
-		handleCard(&card, lastActive, led, s)
-
-		if playing {
-			lastActive = card.CardID
-		} else {
-			checkTiger()
+		station, err := radio.New(u, name, "")
+		if err != nil {
+			log.Fatalf("Invalid station %v: %v", name, err)
 		}
-		playSync.Unlock()
+		cfg.Stations = append(cfg.Stations, *station)
 	}
-}
-
-func isPlaying(event *nfc.CardEvent) bool {
-	return event.State == nfc.Activated
-}
-
-func handleCard(card *nfc.CardEvent, lastActive string, led ui.ColorLed, speaker *sonos.SonosSpeaker) {
-	if card.State == nfc.Activated {
-		log.Infof("Card %v activated", card.CardID)
-		led.Purple()
-
-		p, err := db.ReadCard(card.CardID)
-		if err != nil {
-			log.Errorln(err)
-			return
+	if *bedtime != "" {
+		if cfg.Bedtime, err = player.ParseTimeOfDay(*bedtime); err != nil {
+			log.Fatal(err)
 		}
-		speaker.SetPlaylist(p)
-		// apparently this returns before the player is ready sometimes
-		time.Sleep(750 * time.Millisecond)
-
-		speaker.Play()
-
-		led.Green()
-	} else {
-		log.Infoln("Card removed...")
-		if state, err := speaker.MediaInfo(); err == nil {
-			if p, err := db.ReadCard(lastActive); err == nil {
-				i, err := strconv.Atoi(state.Track)
-				if err != nil {
-					log.Warnf("Could not parse current track: %v", err.Error())
-					i = 1
-				}
-
-				p.State = &sonos.CardStatus{
-					CurrentTrack:    i,
-					CurrentPosition: state.RelTime,
-				}
+	}
+	if cfg.Rules, err = player.ParseRules(*quietHours, *dailyLimit); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.StoreRules(cfg.Rules); err != nil {
+		log.Warn("Could not store the rules: ", err)
+	}
 
-				if err := db.StoreCard(&p); err != nil {
-					log.Warn("Could not update playlist state: ", err)
-				} else {
-					log.Debugf("Updated card %v with state %v", lastActive, p.State)
-				}
-			}
-		} else {
-			log.Warn("Could not fetch the player state to save it ", err)
+	if *mockReader == "-" {
+		// the terminal controls of a development build take standard input, so the reader commands are typed in to them.
+		if input := ui.ReaderInput(); input != nil {
+			nfc.MockInput = input
 		}
-		speaker.Pause()
-		led.Off()
 	}
-}
+	defer ui.Close()
+	reader, err := nfc.CreateReader()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
 
-func handleButton(b *ui.ButtonEvent, playing bool, tiger ui.Tiger, led ui.ColorLed, speaker *sonos.SonosSpeaker) {
-	log.Debugln(b)
-	switch b.Button {
-	case ui.TigerSwitch:
-		if b.Pressed {
-			if !playing {
-				tiger.On()
-				led.Red()
-			}
-			tigerArmed = true
-		} else {
-			tigerArmed = false
-			tiger.Off()
-			if playing {
-				led.Green()
-			} else {
-				led.Off()
-			}
+	p := player.New(s, store, ui.GetColorLED(), ui.InitTiger(), cfg)
+	d, err := serveDaemon(*socketPath, store, p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+	if *httpAddr != "" {
+		if *httpToken == "" {
+			kingpin.FatalUsage("A token must be specified for the HTTP API")
 		}
-	case ui.Red:
-		if b.Pressed && playing {
-			led.Yellow()
-			speaker.Previous()
-			time.Sleep(400 * time.Millisecond)
-			led.Green()
+		api, err := serveAPI(*httpAddr, *httpToken, p, s)
+		if err != nil {
+			log.Fatal(err)
 		}
-	case ui.Blue:
-		if b.Pressed && playing {
-			led.Cyan()
-			speaker.Next()
-			time.Sleep(400 * time.Millisecond)
-			led.Green()
+		defer api.Close()
+	}
+	if len(*hooks) > 0 {
+		if err := runHooks(*hooks, *hookTimeout, p); err != nil {
+			kingpin.FatalUsage(err.Error())
 		}
 	}
-}
-
-func tigerCheck(tiger ui.Tiger, led ui.ColorLed) func() {
-	return func() {
-		if tigerArmed {
-			log.Info("Tiger switched on already, enabling tiger.")
-			led.Red()
-			tiger.On()
+	if *mqttBroker != "" {
+		m, err := serveMQTT(*mqttBroker, *mqttUser, *mqttPassword, *mqttTopic, *mqttDiscover, p)
+		if err != nil {
+			log.Fatal(err)
 		}
+		defer m.Close()
 	}
+	notifySystemd(p, reader)
+	p.Run(reader.Events(), reader.Status(), ui.InitButtons(), player.WatchSpeaker(s, speakerPollInterval))
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+)
+
+// serveMetrics serves the Prometheus metrics of the player on /metrics.
+func serveMetrics(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Error("Metrics server stopped: ", err)
+		}
+	}()
+	log.Infof("Serving metrics on %v/metrics", l.Addr())
+	return l, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mqttQos         = 1
+	mqttWaitTimeout = 5 * time.Second
+)
+
+// mqttPlayer is what the MQTT client needs from the player.
+type mqttPlayer interface {
+	Status() player.Status
+	Watch() <-chan player.Status
+	Command(c player.Command) error
+	SetVolume(volume int) error
+	SetTiger(armed bool) error
+}
+
+// mqttClient publishes the state of the player to an MQTT broker, and takes commands from it. It also publishes the
+// Home Assistant discovery config, so that the player shows up as a device there.
+type mqttClient struct {
+	client    paho.Client
+	player    mqttPlayer
+	topic     string
+	discovery string
+}
+
+// mqttState is what is published on the state topic.
+type mqttState struct {
+	State  string `json:"state"`
+	Card   string `json:"card"`
+	Title  string `json:"title"`
+	Led    string `json:"led"`
+	Volume int    `json:"volume"`
+	Tiger  string `json:"tiger"`
+}
+
+// haDevice is the device that all entities of the player belong to in Home Assistant.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+}
+
+// haEntity is the discovery config of a single entity. Only the fields that are needed for the kind of entity are set.
+type haEntity struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	Icon              string   `json:"icon,omitempty"`
+	Device            haDevice `json:"device"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	StateTopic        string   `json:"state_topic,omitempty"`
+	ValueTemplate     string   `json:"value_template,omitempty"`
+	CommandTopic      string   `json:"command_topic,omitempty"`
+	PayloadPress      string   `json:"payload_press,omitempty"`
+	PayloadOn         string   `json:"payload_on,omitempty"`
+	PayloadOff        string   `json:"payload_off,omitempty"`
+	Min               *int     `json:"min,omitempty"`
+	Max               *int     `json:"max,omitempty"`
+	// the media player of the MQTT Media Player integration takes its state from, and sends its commands to, topics
+	// of their own.
+	StateStateTopic        string `json:"state_state_topic,omitempty"`
+	StateTitleTopic        string `json:"state_title_topic,omitempty"`
+	StateVolumeTopic       string `json:"state_volume_topic,omitempty"`
+	CommandVolumeTopic     string `json:"command_volume_topic,omitempty"`
+	CommandPlayTopic       string `json:"command_play_topic,omitempty"`
+	CommandPlayPayload     string `json:"command_play_payload,omitempty"`
+	CommandPauseTopic      string `json:"command_pause_topic,omitempty"`
+	CommandPausePayload    string `json:"command_pause_payload,omitempty"`
+	CommandNextTopic       string `json:"command_next_topic,omitempty"`
+	CommandNextPayload     string `json:"command_next_payload,omitempty"`
+	CommandPreviousTopic   string `json:"command_previous_topic,omitempty"`
+	CommandPreviousPayload string `json:"command_previous_payload,omitempty"`
+
+	component string
+	object    string
+}
+
+var nodeIDChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// serveMQTT connects to the broker, and keeps the state of the player published there until Close is called. If the
+// broker can't be reached, it keeps trying in the background.
+func serveMQTT(broker, user, password, topic, discovery string, p mqttPlayer) (*mqttClient, error) {
+	if topic == "" {
+		return nil, errors.New("the MQTT topic can't be empty")
+	}
+	m := &mqttClient{player: p, topic: strings.TrimSuffix(topic, "/"), discovery: strings.TrimSuffix(discovery, "/")}
+
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(m.nodeID()).
+		SetUsername(user).
+		SetPassword(password).
+		SetWill(m.topic+"/availability", "offline", mqttQos, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		// commands wait for the player, which must not hold up the other messages.
+		SetOrderMatters(false).
+		SetOnConnectHandler(m.connected).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Warn("Lost the connection to the MQTT broker: ", err)
+		})
+	m.client = paho.NewClient(opts)
+	if t := m.client.Connect(); !t.WaitTimeout(mqttWaitTimeout) {
+		log.Warnf("Could not connect to the MQTT broker at %v yet, retrying in the background", broker)
+	} else if t.Error() != nil {
+		return nil, t.Error()
+	}
+
+	go func() {
+		for s := range p.Watch() {
+			m.publishState(s)
+		}
+	}()
+	log.Infof("Publishing the player on MQTT as %v", m.topic)
+	return m, nil
+}
+
+// Close tells the broker that the player is going away, and disconnects.
+func (m *mqttClient) Close() {
+	if m.client.IsConnected() {
+		m.client.Publish(m.topic+"/availability", mqttQos, true, "offline").WaitTimeout(mqttWaitTimeout)
+	}
+	m.client.Disconnect(250)
+}
+
+// connected is called every time the connection to the broker is made, so that everything is published again after
+// the broker has restarted.
+func (m *mqttClient) connected(c paho.Client) {
+	log.Info("Connected to the MQTT broker")
+	subs := map[string]paho.MessageHandler{
+		m.topic + "/command":    m.command,
+		m.topic + "/volume/set": m.volume,
+		m.topic + "/tiger/set":  m.tiger,
+		// the media player sends the volume between 0 and 1.
+		m.topic + "/media/volume/set": m.mediaVolume,
+	}
+	for topic, handler := range subs {
+		if t := c.Subscribe(topic, mqttQos, handler); t.WaitTimeout(mqttWaitTimeout) && t.Error() != nil {
+			log.Errorf("Could not subscribe to %v: %v", topic, t.Error())
+		}
+	}
+	if m.discovery != "" {
+		for _, e := range m.entities() {
+			m.publish(fmt.Sprintf("%s/%s/%s/%s/config", m.discovery, e.component, m.nodeID(), e.object), e)
+		}
+	}
+	m.publish(m.topic+"/availability", "online")
+	m.publishState(m.player.Status())
+}
+
+func (m *mqttClient) publishState(s player.Status) {
+	state := mqttState{State: s.State.String(), Led: s.Led, Volume: s.Volume, Tiger: "OFF"}
+	if s.Card != nil {
+		state.Card = s.Card.ID
+		state.Title = s.Card.Title
+	}
+	if s.TigerArmed {
+		state.Tiger = "ON"
+	}
+	m.publish(m.topic+"/state", state)
+
+	// the media player only knows about playing, paused and idle, and reads every value from a topic of its own.
+	media := "idle"
+	if s.State == player.Playing || s.State == player.Paused {
+		media = s.State.String()
+	}
+	m.publish(m.topic+"/media/state", media)
+	m.publish(m.topic+"/media/title", state.Title)
+	m.publish(m.topic+"/media/volume", strconv.FormatFloat(float64(s.Volume)/100, 'f', 2, 64))
+}
+
+// publish sends a retained message. Strings are sent as they are, and anything else as JSON.
+func (m *mqttClient) publish(topic string, payload interface{}) {
+	if !m.client.IsConnected() {
+		return
+	}
+	b, ok := payload.(string)
+	if !ok {
+		j, err := json.Marshal(payload)
+		if err != nil {
+			log.Errorf("Could not encode the message for %v: %v", topic, err)
+			return
+		}
+		b = string(j)
+	}
+	if t := m.client.Publish(topic, mqttQos, true, b); t.WaitTimeout(mqttWaitTimeout) && t.Error() != nil {
+		log.Warnf("Could not publish to %v: %v", topic, t.Error())
+	}
+}
+
+func (m *mqttClient) command(_ paho.Client, msg paho.Message) {
+	c := player.Command(strings.ToLower(strings.TrimSpace(string(msg.Payload()))))
+	log.Debugf("MQTT command %v", c)
+	if err := m.player.Command(c); err != nil {
+		log.Warnf("Could not %v: %v", c, err)
+	}
+}
+
+func (m *mqttClient) volume(_ paho.Client, msg paho.Message) {
+	// Home Assistant sends numbers as floats, like 35.0.
+	v, err := strconv.ParseFloat(strings.TrimSpace(string(msg.Payload())), 64)
+	if err != nil {
+		log.Warnf("Invalid volume %q", msg.Payload())
+		return
+	}
+	if err := m.player.SetVolume(int(v)); err != nil {
+		log.Warn("Could not set the volume: ", err)
+	}
+}
+
+func (m *mqttClient) mediaVolume(_ paho.Client, msg paho.Message) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(string(msg.Payload())), 64)
+	if err != nil || v < 0 || v > 1 {
+		log.Warnf("Invalid media player volume %q", msg.Payload())
+		return
+	}
+	if err := m.player.SetVolume(int(math.Round(v * 100))); err != nil {
+		log.Warn("Could not set the volume: ", err)
+	}
+}
+
+func (m *mqttClient) tiger(_ paho.Client, msg paho.Message) {
+	var err error
+	switch strings.ToUpper(strings.TrimSpace(string(msg.Payload()))) {
+	case "ON":
+		err = m.player.SetTiger(true)
+	case "OFF":
+		err = m.player.SetTiger(false)
+	default:
+		log.Warnf("Invalid tiger state %q", msg.Payload())
+	}
+	if err != nil {
+		log.Warn("Could not set the tiger: ", err)
+	}
+}
+
+// nodeID identifies the player towards the broker and Home Assistant. It's made from the topic, so that several
+// players can share a broker by using different topics.
+func (m *mqttClient) nodeID() string {
+	return nodeIDChars.ReplaceAllString(m.topic, "_")
+}
+
+// entities are the Home Assistant entities of the player. Home Assistant itself has no MQTT media player, so the player
+// is made up of sensors, buttons, a number for the volume and a switch for the tiger, all on the same device. With the
+// MQTT Media Player integration (https://github.com/bkbilly/mqtt_media_player) installed, it's a media player as well.
+func (m *mqttClient) entities() []haEntity {
+	device := haDevice{
+		Identifiers:  []string{m.nodeID()},
+		Name:         "Tiger player",
+		Model:        "rpi-nfc-player",
+		Manufacturer: "callebjorkell",
+		SwVersion:    buildVersion,
+	}
+	entity := func(component, object, name, icon string) haEntity {
+		return haEntity{
+			Name:              name,
+			UniqueID:          m.nodeID() + "_" + object,
+			Icon:              icon,
+			Device:            device,
+			AvailabilityTopic: m.topic + "/availability",
+			component:         component,
+			object:            object,
+		}
+	}
+	sensor := func(field, name, icon string) haEntity {
+		e := entity("sensor", field, name, icon)
+		e.StateTopic = m.topic + "/state"
+		e.ValueTemplate = fmt.Sprintf("{{ value_json.%s }}", field)
+		return e
+	}
+	button := func(c player.Command, name, icon string) haEntity {
+		e := entity("button", string(c), name, icon)
+		e.CommandTopic = m.topic + "/command"
+		e.PayloadPress = string(c)
+		return e
+	}
+
+	volume := entity("number", "volume", "Volume", "mdi:volume-high")
+	volume.StateTopic = m.topic + "/state"
+	volume.ValueTemplate = "{{ value_json.volume }}"
+	volume.CommandTopic = m.topic + "/volume/set"
+	minVolume, maxVolume := 0, 100
+	volume.Min, volume.Max = &minVolume, &maxVolume
+
+	tiger := entity("switch", "tiger", "Tiger", "mdi:cat")
+	tiger.StateTopic = m.topic + "/state"
+	tiger.ValueTemplate = "{{ value_json.tiger }}"
+	tiger.CommandTopic = m.topic + "/tiger/set"
+	tiger.PayloadOn, tiger.PayloadOff = "ON", "OFF"
+
+	media := entity("media_player", "player", "Player", "mdi:speaker")
+	media.StateStateTopic = m.topic + "/media/state"
+	media.StateTitleTopic = m.topic + "/media/title"
+	media.StateVolumeTopic = m.topic + "/media/volume"
+	media.CommandVolumeTopic = m.topic + "/media/volume/set"
+	media.CommandPlayTopic, media.CommandPlayPayload = m.topic+"/command", string(player.CommandPlay)
+	media.CommandPauseTopic, media.CommandPausePayload = m.topic+"/command", string(player.CommandPause)
+	media.CommandNextTopic, media.CommandNextPayload = m.topic+"/command", string(player.CommandNext)
+	media.CommandPreviousTopic, media.CommandPreviousPayload = m.topic+"/command", string(player.CommandPrevious)
+
+	return []haEntity{
+		media,
+		sensor("state", "State", "mdi:play-pause"),
+		sensor("card", "Card", "mdi:card-bulleted"),
+		sensor("title", "Title", "mdi:music"),
+		sensor("led", "LED", "mdi:led-on"),
+		button(player.CommandPlay, "Play", "mdi:play"),
+		button(player.CommandPause, "Pause", "mdi:pause"),
+		button(player.CommandNext, "Next", "mdi:skip-next"),
+		button(player.CommandPrevious, "Previous", "mdi:skip-previous"),
+		volume,
+		tiger,
+	}
+}
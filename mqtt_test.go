@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakePlayer struct {
+	lock   sync.Mutex
+	calls  []string
+	status player.Status
+}
+
+func (p *fakePlayer) call(format string, args ...interface{}) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.calls = append(p.calls, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (p *fakePlayer) called() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string{}, p.calls...)
+}
+
+func (p *fakePlayer) Status() player.Status          { return p.status }
+func (p *fakePlayer) Watch() <-chan player.Status    { return make(chan player.Status) }
+func (p *fakePlayer) Command(c player.Command) error { return p.call("Command %v", c) }
+func (p *fakePlayer) SetVolume(volume int) error     { return p.call("SetVolume %v", volume) }
+func (p *fakePlayer) SetTiger(armed bool) error      { return p.call("SetTiger %v", armed) }
+
+type fakeMessage struct {
+	topic   string
+	payload string
+}
+
+func (m fakeMessage) Duplicate() bool   { return false }
+func (m fakeMessage) Qos() byte         { return mqttQos }
+func (m fakeMessage) Retained() bool    { return false }
+func (m fakeMessage) Topic() string     { return m.topic }
+func (m fakeMessage) MessageID() uint16 { return 0 }
+func (m fakeMessage) Payload() []byte   { return []byte(m.payload) }
+func (m fakeMessage) Ack()              {}
+
+func TestMQTTDiscovery(t *testing.T) {
+	m := &mqttClient{topic: "tigerplayer", discovery: "homeassistant"}
+	entities := map[string]haEntity{}
+	for _, e := range m.entities() {
+		entities[e.component+"/"+e.object] = e
+	}
+
+	tests := []struct {
+		entity string
+		// fields are the fields that the discovery config of the entity must have, as they are encoded.
+		fields map[string]interface{}
+	}{
+		{
+			entity: "media_player/player",
+			fields: map[string]interface{}{
+				"unique_id":              "tigerplayer_player",
+				"availability_topic":     "tigerplayer/availability",
+				"state_state_topic":      "tigerplayer/media/state",
+				"state_title_topic":      "tigerplayer/media/title",
+				"state_volume_topic":     "tigerplayer/media/volume",
+				"command_volume_topic":   "tigerplayer/media/volume/set",
+				"command_play_topic":     "tigerplayer/command",
+				"command_play_payload":   "play",
+				"command_pause_payload":  "pause",
+				"command_next_payload":   "next",
+				"command_previous_topic": "tigerplayer/command",
+			},
+		},
+		{
+			entity: "sensor/title",
+			fields: map[string]interface{}{
+				"state_topic":    "tigerplayer/state",
+				"value_template": "{{ value_json.title }}",
+			},
+		},
+		{
+			entity: "button/next",
+			fields: map[string]interface{}{
+				"command_topic": "tigerplayer/command",
+				"payload_press": "next",
+			},
+		},
+		{
+			entity: "number/volume",
+			fields: map[string]interface{}{
+				"command_topic": "tigerplayer/volume/set",
+				"min":           0.0,
+				"max":           100.0,
+			},
+		},
+		{
+			entity: "switch/tiger",
+			fields: map[string]interface{}{
+				"command_topic": "tigerplayer/tiger/set",
+				"payload_on":    "ON",
+				"payload_off":   "OFF",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.entity, func(t *testing.T) {
+			e, ok := entities[test.entity]
+			if !ok {
+				t.Fatalf("expected a %v entity", test.entity)
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var config map[string]interface{}
+			if err := json.Unmarshal(b, &config); err != nil {
+				t.Fatal(err)
+			}
+			for field, expected := range test.fields {
+				if !reflect.DeepEqual(config[field], expected) {
+					t.Errorf("expected %v to be %v, got %v", field, expected, config[field])
+				}
+			}
+		})
+	}
+}
+
+func TestMQTTCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		calls   []string
+	}{
+		{name: "command", topic: "command", payload: " Next\n", calls: []string{"Command next"}},
+		{name: "volume", topic: "volume", payload: "35.0", calls: []string{"SetVolume 35"}},
+		{name: "invalid volume", topic: "volume", payload: "loud"},
+		{name: "media player volume", topic: "media volume", payload: "0.35", calls: []string{"SetVolume 35"}},
+		{name: "media player volume out of range", topic: "media volume", payload: "35"},
+		{name: "tiger on", topic: "tiger", payload: "ON", calls: []string{"SetTiger true"}},
+		{name: "tiger off", topic: "tiger", payload: "off", calls: []string{"SetTiger false"}},
+		{name: "invalid tiger", topic: "tiger", payload: "maybe"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &fakePlayer{}
+			m := &mqttClient{player: p, topic: "tigerplayer"}
+			handlers := map[string]paho.MessageHandler{
+				"command":      m.command,
+				"volume":       m.volume,
+				"media volume": m.mediaVolume,
+				"tiger":        m.tiger,
+			}
+			handlers[test.topic](nil, fakeMessage{topic: test.topic, payload: test.payload})
+			if calls := p.called(); (len(calls) > 0 || len(test.calls) > 0) && !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("expected the calls %v, got %v", test.calls, calls)
+			}
+		})
+	}
+}
+
+// TestMQTTBroker runs the player against a real broker, like a local mosquitto, when MQTT_TEST_BROKER is set to its
+// address, like tcp://localhost:1883.
+func TestMQTTBroker(t *testing.T) {
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER is not set")
+	}
+	topic, discovery := "tigerplayer-test", "homeassistant-test"
+
+	received := make(chan paho.Message, 100)
+	c := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID("rpi-nfc-player-test"))
+	if tok := c.Connect(); !tok.WaitTimeout(mqttWaitTimeout) || tok.Error() != nil {
+		t.Fatalf("could not connect to %v: %v", broker, tok.Error())
+	}
+	t.Cleanup(func() {
+		c.Disconnect(250)
+	})
+	filters := map[string]byte{topic + "/#": mqttQos, discovery + "/#": mqttQos}
+	if tok := c.SubscribeMultiple(filters, func(_ paho.Client, msg paho.Message) {
+		received <- msg
+	}); !tok.WaitTimeout(mqttWaitTimeout) || tok.Error() != nil {
+		t.Fatal("could not subscribe: ", tok.Error())
+	}
+
+	p := &fakePlayer{status: player.Status{State: player.Playing, Volume: 35}}
+	m, err := serveMQTT(broker, "", "", topic, discovery, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Close()
+		// clear the retained messages, so that they don't show up in the next run.
+		topics := []string{"availability", "state", "media/state", "media/title", "media/volume"}
+		for _, tp := range topics {
+			c.Publish(topic+"/"+tp, mqttQos, true, "").WaitTimeout(mqttWaitTimeout)
+		}
+		for _, e := range m.entities() {
+			config := fmt.Sprintf("%s/%s/%s/%s/config", discovery, e.component, m.nodeID(), e.object)
+			c.Publish(config, mqttQos, true, "").WaitTimeout(mqttWaitTimeout)
+		}
+	})
+
+	expected := map[string]string{
+		topic + "/availability": "online",
+		topic + "/media/state":  "playing",
+		topic + "/media/volume": "0.35",
+	}
+	configs := 0
+	timeout := time.After(10 * time.Second)
+	for len(expected) > 0 || configs == 0 {
+		select {
+		case msg := <-received:
+			if want, ok := expected[msg.Topic()]; ok && string(msg.Payload()) == want {
+				delete(expected, msg.Topic())
+			}
+			if msg.Topic() == fmt.Sprintf("%s/media_player/%s/player/config", discovery, topic) {
+				configs++
+			}
+		case <-timeout:
+			t.Fatalf("expected the player to be published, still missing %v and %v configs", expected, configs)
+		}
+	}
+
+	c.Publish(topic+"/command", mqttQos, false, "next").WaitTimeout(mqttWaitTimeout)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if calls := p.called(); len(calls) == 1 && calls[0] == "Command next" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the command to reach the player, got %v", p.called())
+		}
+	}
+
+}
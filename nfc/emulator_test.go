@@ -0,0 +1,258 @@
+package nfc
+
+import (
+	"github.com/jdevelop/golang-rpi-extras/rf522/commands"
+)
+
+// emulator is an MFRC522 on an SPI bus, with a card that can be put on and taken off. It models the registers that the
+// driver uses: the FIFO, the command register, the CRC coprocessor, and a card that answers REQA and the anticollision
+// and select commands of cascade levels 1 and 2. It is also the reset pin of the reader.
+type emulator struct {
+	regs [64]byte
+	fifo []byte
+	// card is the UID of the card on the reader, 4 or 7 bytes, or nil when there is no card.
+	card []byte
+	// level is the cascade level that the card is at, 0 until it has answered a REQA.
+	level int
+	// hung makes the reader stop answering, until it's reset through the reset pin. broken makes it stop answering
+	// for good.
+	hung   bool
+	broken bool
+	// poweredDown is set while the reset pin is low.
+	poweredDown bool
+}
+
+const emulatedVersion = 0x92
+
+func newEmulator() *emulator {
+	e := &emulator{}
+	e.reset()
+	return e
+}
+
+// newEmulatedReader returns a reader that talks to the emulator, initialized like the real one.
+func newEmulatedReader(e *emulator) *rfid {
+	r := &rfid{spi: e, ResetPin: e, antennaGain: 7}
+	if err := r.init(); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// reset puts the registers to their reset values, as after a soft reset or a power up.
+func (e *emulator) reset() {
+	e.regs = [64]byte{}
+	e.regs[commands.CommandReg] = 0x20
+	e.regs[commands.CommIEnReg] = 0x80
+	e.regs[commands.CommIrqReg] = 0x14
+	e.regs[commands.TxControlReg] = 0x80
+	e.regs[commands.VersionReg] = emulatedVersion
+	e.fifo = nil
+}
+
+func (e *emulator) Transfer(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	if e.hung || e.broken || e.poweredDown {
+		for i := range buf {
+			buf[i] = 0
+		}
+		return nil
+	}
+	address := int(buf[0]>>1) & 0x3F
+	if buf[0]&0x80 != 0 {
+		// a read sends the addresses to read one after the other, and gets each value back with the next byte.
+		for i := 1; i < len(buf); i++ {
+			next := int(buf[i]>>1) & 0x3F
+			buf[i] = e.read(address)
+			address = next
+		}
+		buf[0] = 0
+		return nil
+	}
+	for i := 1; i < len(buf); i++ {
+		e.write(address, buf[i])
+		buf[i] = 0
+	}
+	buf[0] = 0
+	return nil
+}
+
+func (e *emulator) Close() error {
+	return nil
+}
+
+// Clear pulls the reset pin low, which powers the reader down.
+func (e *emulator) Clear() {
+	e.poweredDown = true
+}
+
+// Set lets the reset pin go high again, which starts the reader up from scratch.
+func (e *emulator) Set() {
+	if e.poweredDown {
+		e.poweredDown = false
+		e.hung = false
+		e.reset()
+	}
+}
+
+func (e *emulator) read(address int) byte {
+	switch address {
+	case commands.FIFODataReg:
+		if len(e.fifo) == 0 {
+			return 0
+		}
+		v := e.fifo[0]
+		e.fifo = e.fifo[1:]
+		return v
+	case commands.FIFOLevelReg:
+		return byte(len(e.fifo))
+	}
+	return e.regs[address]
+}
+
+func (e *emulator) write(address int, v byte) {
+	switch address {
+	case commands.CommandReg:
+		e.regs[address] = e.regs[address]&0xF0 | v&0x0F
+		e.command(v & 0x0F)
+	case commands.FIFODataReg:
+		if len(e.fifo) < 64 {
+			e.fifo = append(e.fifo, v)
+		}
+	case commands.FIFOLevelReg:
+		if v&0x80 != 0 {
+			e.fifo = nil
+		}
+	case commands.CommIrqReg, commands.DivIrqReg:
+		// the top bit says whether the marked bits are set or cleared.
+		if v&0x80 != 0 {
+			e.regs[address] |= v & 0x7F
+		} else {
+			e.regs[address] &^= v & 0x7F
+		}
+	case commands.BitFramingReg:
+		e.regs[address] = v
+		if v&0x80 != 0 && e.regs[commands.CommandReg]&0x0F == commands.PCD_TRANSCEIVE {
+			e.transceive(int(v & 0x07))
+		}
+	case commands.VersionReg, commands.ErrorReg:
+		// read only
+	default:
+		e.regs[address] = v
+	}
+}
+
+func (e *emulator) command(c byte) {
+	switch c {
+	case commands.PCD_RESETPHASE:
+		e.reset()
+	case commands.PCD_CALCCRC:
+		crc := crcA(e.fifo)
+		e.fifo = nil
+		e.regs[commands.CRCResultRegL] = crc[0]
+		e.regs[commands.CRCResultRegM] = crc[1]
+		e.regs[commands.DivIrqReg] |= 0x04
+		e.regs[commands.CommandReg] &^= 0x0F
+	}
+}
+
+// transceive sends what is in the FIFO to the card, and puts the answer in the FIFO. lastBits is how many bits of the
+// last byte are sent, 0 for all of them.
+func (e *emulator) transceive(lastBits int) {
+	frame := e.fifo
+	e.fifo = nil
+	e.regs[commands.ControlReg] &^= 0x07
+	answer := e.answer(frame, lastBits)
+	if answer == nil {
+		// nothing answered before the timer ran out.
+		e.regs[commands.CommIrqReg] |= 0x41
+		return
+	}
+	e.fifo = answer
+	e.regs[commands.CommIrqReg] |= 0x70
+}
+
+// answer is what the card on the reader answers to a frame, or nil if it doesn't.
+func (e *emulator) answer(frame []byte, lastBits int) []byte {
+	if e.card == nil || e.regs[commands.TxControlReg]&0x03 == 0 || len(frame) == 0 {
+		return nil
+	}
+	if lastBits == 7 {
+		if len(frame) != 1 || (frame[0] != commands.PICC_REQIDL && frame[0] != commands.PICC_REQALL) {
+			return nil
+		}
+		e.level = 1
+		if len(e.card) == 7 {
+			return []byte{0x44, 0x00}
+		}
+		return []byte{0x04, 0x00}
+	}
+
+	level, part := 0, e.uidPart(1)
+	switch frame[0] {
+	case 0x93:
+		level = 1
+	case 0x95:
+		level = 2
+		part = e.uidPart(2)
+	default:
+		return nil
+	}
+	if level != e.level || part == nil {
+		e.level = 0
+		return nil
+	}
+	switch {
+	case len(frame) == 2 && frame[1] == 0x20:
+		return append(part, bcc(part))
+	case len(frame) == 9 && frame[1] == 0x70:
+		crc := crcA(frame[:7])
+		if frame[7] != crc[0] || frame[8] != crc[1] || string(frame[2:6]) != string(part) || frame[6] != bcc(part) {
+			e.level = 0
+			return nil
+		}
+		sak := byte(0x08)
+		if level == 1 && len(e.card) == 7 {
+			// the UID isn't complete yet.
+			sak = 0x04
+			e.level = 2
+		}
+		crc = crcA([]byte{sak})
+		return []byte{sak, crc[0], crc[1]}
+	}
+	return nil
+}
+
+// uidPart is the part of the UID that the card gives on a cascade level, with the cascade tag when there is more.
+func (e *emulator) uidPart(level int) []byte {
+	switch {
+	case len(e.card) == 4 && level == 1:
+		return append([]byte{}, e.card...)
+	case len(e.card) == 7 && level == 1:
+		return []byte{0x88, e.card[0], e.card[1], e.card[2]}
+	case len(e.card) == 7 && level == 2:
+		return append([]byte{}, e.card[3:]...)
+	}
+	return nil
+}
+
+func bcc(data []byte) byte {
+	b := byte(0)
+	for _, v := range data {
+		b ^= v
+	}
+	return b
+}
+
+// crcA is the CRC of ISO/IEC 14443-3 type A, least significant byte first.
+func crcA(data []byte) [2]byte {
+	crc := uint16(0x6363)
+	for _, b := range data {
+		b ^= byte(crc)
+		b ^= b << 4
+		crc = crc>>8 ^ uint16(b)<<8 ^ uint16(b)<<3 ^ uint16(b)>>4
+	}
+	return [2]byte{byte(crc), byte(crc >> 8)}
+}
@@ -0,0 +1,43 @@
+package nfc
+
+// chip is what checking the health of the reader needs from it.
+type chip interface {
+	// checkVersion reads back the version of the reader, which fails when it doesn't answer.
+	checkVersion() error
+	// hardReset resets the reader with its reset pin, and initializes it again.
+	hardReset() error
+}
+
+// setStatus sends the status of the reader. Only the latest status is kept, so that the polling never waits for it.
+func setStatus(status chan ReaderStatus, s ReaderStatus) {
+	select {
+	case <-status:
+	default:
+	}
+	status <- s
+}
+
+// checkHealth checks that the reader answers, and resets it if it doesn't. The status is sent whenever the health of the
+// reader changes.
+func checkHealth(r chip, healthy bool, status chan ReaderStatus) bool {
+	err := r.checkVersion()
+	if err != nil {
+		if healthy {
+			log.Warn("The card reader is not answering, resetting it: ", err)
+			setStatus(status, ReaderStatus{Err: err})
+			healthy = false
+		}
+		if err = r.hardReset(); err == nil {
+			err = r.checkVersion()
+		}
+		if err != nil {
+			log.Debug("The card reader is still not answering: ", err)
+			return false
+		}
+	}
+	if !healthy {
+		log.Info("The card reader is answering again")
+		setStatus(status, ReaderStatus{OK: true})
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package nfc
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeChip answers until it hangs, and answers again after a reset unless it's broken.
+type fakeChip struct {
+	hung, broken bool
+	resets       int
+}
+
+func (c *fakeChip) checkVersion() error {
+	if c.hung || c.broken {
+		return errors.New("the reader answered with version 0x00")
+	}
+	return nil
+}
+
+func (c *fakeChip) hardReset() error {
+	c.resets++
+	c.hung = false
+	return nil
+}
+
+func TestCheckHealth(t *testing.T) {
+	ok, failed := true, false
+	tests := []struct {
+		name    string
+		chip    fakeChip
+		healthy bool
+		// expected is what checkHealth returns, status the latest status that was sent, if any, and resets how often
+		// the chip is reset.
+		expected bool
+		status   *bool
+		resets   int
+	}{
+		{name: "healthy reader is left alone", healthy: true, expected: true},
+		{name: "reset recovers a hung reader", chip: fakeChip{hung: true}, healthy: true, expected: true,
+			status: &ok, resets: 1},
+		{name: "broken reader fails", chip: fakeChip{broken: true}, healthy: true, expected: false,
+			status: &failed, resets: 1},
+		{name: "failed reader is reset again without another status", chip: fakeChip{broken: true}, expected: false,
+			resets: 1},
+		{name: "failed reader that answers again recovers", expected: true, status: &ok},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := make(chan ReaderStatus, 1)
+			chip := test.chip
+			if healthy := checkHealth(&chip, test.healthy, status); healthy != test.expected {
+				t.Errorf("expected healthy to be %v, got %v", test.expected, healthy)
+			}
+			select {
+			case s := <-status:
+				if test.status == nil {
+					t.Errorf("expected no status, got %+v", s)
+				} else if s.OK != *test.status {
+					t.Errorf("expected the status to be %v, got %+v", *test.status, s)
+				}
+				if !s.OK && s.Err == nil {
+					t.Error("expected a failed status to have an error")
+				}
+			default:
+				if test.status != nil {
+					t.Errorf("expected the status to be %v, got nothing", *test.status)
+				}
+			}
+			if chip.resets != test.resets {
+				t.Errorf("expected %v resets, got %v", test.resets, chip.resets)
+			}
+		})
+	}
+}
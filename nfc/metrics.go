@@ -0,0 +1,25 @@
+package nfc
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nfc_events_total",
+		Help: "Card events sent by the reader.",
+	}, []string{"state"})
+	readErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nfc_read_errors_total",
+		Help: "Failed attempts to read a card ID, not counting when there is no card.",
+	})
+	debounceFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nfc_debounce_failures_total",
+		Help: "Reads that disagreed with the reads before them while a card was being debounced.",
+	})
+	readerResetsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nfc_reader_resets_total",
+		Help: "Times the reader was reset because it stopped answering.",
+	})
+)
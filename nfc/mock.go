@@ -3,44 +3,251 @@
 package nfc
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
+/*
+ * The mock reader stands in for the RC522 when not building for the Raspberry Pi. It takes the cards that are put on
+ * it from a script of commands, one per line, read from MockSource:
+ *
+ *   tap <id>          put a card on the reader, instead of the one that was on it
+ *   tap <id> <id>...  put several cards on the reader at once, which it can't read, just like the real one
+ *   remove            take the cards off the reader
+ *   wait <duration>   wait before the next command, like 5s or 500ms
+ *   fail [reason]     make the reader stop answering
+ *   recover           make the reader answer again
+ *
+ * Empty lines and lines starting with # are skipped. The reader is polled and debounced like the real one, so cards
+ * come and go with the same lag.
+ */
+
 func CreateReader() (CardReader, error) {
-	return mockReader{
-		init:   &sync.Once{},
-		events: make(chan CardEvent, 2),
-	}, nil
+	m := &mockReader{
+		events: make(chan CardEvent, 10),
+		status: make(chan ReaderStatus, 1),
+		stop:   make(chan interface{}),
+	}
+	if err := m.listen(MockSource); err != nil {
+		return nil, fmt.Errorf("could not read the mock reader commands from %v: %w", MockSource, err)
+	}
+	go m.poll()
+	return m, nil
+}
+
+// CheckReader has no reader to check.
+func CheckReader() (byte, error) {
+	return 0, ErrNoHardware
 }
 
 type mockReader struct {
-	init   *sync.Once
 	events chan CardEvent
+	status chan ReaderStatus
+	stop   chan interface{}
+	// source is the socket or the file that the commands are read from, if they aren't read from standard input.
+	source io.Closer
+
+	lock sync.Mutex
+	// cards are the IDs of the cards on the reader, and failed is set while the reader isn't answering.
+	cards  []string
+	failed bool
 }
 
-func (m mockReader) Close() error {
+func (m *mockReader) Close() error {
+	close(m.stop)
+	if m.source != nil {
+		return m.source.Close()
+	}
 	return nil
 }
 
-func (m mockReader) Events() <-chan CardEvent {
-	m.init.Do(
-		func() {
-			go func() {
-				for {
-					m.events <- CardEvent{
-						CardID: "666",
-						State:  Activated,
-					}
-					<-time.After(30 * time.Second)
-					m.events <- CardEvent{
-						CardID: "666",
-						State:  Deactivated,
-					}
-					<-time.After(10 * time.Second)
-				}
-			}()
-		})
+func (m *mockReader) Status() <-chan ReaderStatus {
+	return m.status
+}
 
+// setStatus sends the status of the reader, keeping only the latest one like the real reader does.
+func (m *mockReader) setStatus(s ReaderStatus) {
+	select {
+	case <-m.status:
+	default:
+	}
+	m.status <- s
+}
+
+// Polled is always now, since the mock reader can't hang.
+func (m *mockReader) Polled() time.Time {
+	return time.Now()
+}
+
+func (m *mockReader) Events() <-chan CardEvent {
 	return m.events
 }
+
+// listen starts reading commands from the source: "-" for standard input, "unix:<path>" for a Unix socket, or the
+// path of a scenario file.
+func (m *mockReader) listen(source string) error {
+	switch {
+	case source == "-":
+		log.Info(`Reading card reader commands, like "tap 04a23b125c6180" or "remove"`)
+		go m.run(MockInput, nil)
+	case strings.HasPrefix(source, "unix:"):
+		path := strings.TrimPrefix(source, "unix:")
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return err
+		}
+		// anyone who can connect can put cards on, so only the user that runs the player may.
+		if err := os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return err
+		}
+		m.source = l
+		log.Infof("Listening for card reader commands on %v", path)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					log.Debug("Stopped listening for card reader commands: ", err)
+					return
+				}
+				go func() {
+					defer conn.Close()
+					m.run(conn, conn)
+				}()
+			}
+		}()
+	default:
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		m.source = f
+		log.Infof("Running the card reader scenario in %v", source)
+		go func() {
+			m.run(f, nil)
+			log.Infof("The card reader scenario in %v is done", source)
+		}()
+	}
+	return nil
+}
+
+// run runs the commands that are read from script until it ends, or the reader is closed. Commands that fail are
+// logged, and written to out when there is someone to tell.
+func (m *mockReader) run(script io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(script)
+	for scanner.Scan() {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+		if err := m.command(scanner.Text()); err != nil {
+			log.Warn("Card reader command failed: ", err)
+			if out != nil {
+				fmt.Fprintln(out, "error:", err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Debug("Could not read card reader commands: ", err)
+	}
+}
+
+func (m *mockReader) command(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "tap":
+		if len(args) == 0 {
+			return errors.New("tap needs the ID of a card")
+		}
+		log.Debugf("Putting %v on the reader", strings.Join(args, " and "))
+		m.setCards(args)
+	case "remove":
+		log.Debug("Taking the cards off the reader")
+		m.setCards(nil)
+	case "wait":
+		if len(args) != 1 {
+			return errors.New("wait needs a duration, like 5s")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		select {
+		case <-m.stop:
+		case <-time.After(d):
+		}
+	case "fail":
+		reason := "the reader is not answering"
+		if len(args) > 0 {
+			reason = strings.Join(args, " ")
+		}
+		m.lock.Lock()
+		m.failed = true
+		m.lock.Unlock()
+		m.setStatus(ReaderStatus{Err: errors.New(reason)})
+	case "recover":
+		m.lock.Lock()
+		m.failed = false
+		m.lock.Unlock()
+		m.setStatus(ReaderStatus{OK: true})
+	default:
+		return fmt.Errorf("unknown command %q", fields[0])
+	}
+	return nil
+}
+
+func (m *mockReader) setCards(cards []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cards = cards
+}
+
+// read is what the reader would read right now: the card on it, or "" when there is no card, or more than one. ok is
+// false when it isn't answering.
+func (m *mockReader) read() (id string, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.failed {
+		return "", false
+	}
+	if len(m.cards) != 1 {
+		return "", true
+	}
+	return m.cards[0], true
+}
+
+func (m *mockReader) poll() {
+	defer close(m.events)
+	var d debouncer
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(pollInterval):
+		}
+
+		id, ok := m.read()
+		if !ok {
+			continue
+		}
+		if e, ok := d.read(id); ok {
+			m.events <- e
+			eventsTotal.WithLabelValues(e.State.String()).Inc()
+		}
+	}
+}
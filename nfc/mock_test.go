@@ -0,0 +1,131 @@
+//go:build !pi
+
+package nfc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMockScenarios runs scenarios through the mock reader, reading it through the debouncer often enough after each
+// step for the cards on it to settle, like the poller would.
+func TestMockScenarios(t *testing.T) {
+	ok, failed := true, false
+	activated := func(id string) CardEvent {
+		return CardEvent{State: Activated, CardID: id}
+	}
+	deactivated := CardEvent{State: Deactivated}
+
+	tests := []struct {
+		name string
+		// steps are the scripts that are run one after the other.
+		steps []string
+		// events are the debounced events, status the latest status that was sent, if any, and out what the reader
+		// wrote back.
+		events []CardEvent
+		status *bool
+		out    string
+	}{
+		{
+			name:   "tap",
+			steps:  []string{"tap deadbeef"},
+			events: []CardEvent{activated("deadbeef")},
+		},
+		{
+			name:   "tap swaps the card",
+			steps:  []string{"tap deadbeef", "tap 04a23b125c6180"},
+			events: []CardEvent{activated("deadbeef"), activated("04a23b125c6180")},
+		},
+		{
+			name:  "several cards can't be read",
+			steps: []string{"tap deadbeef 04a23b125c6180"},
+		},
+		{
+			name:   "several cards take the one that was read off",
+			steps:  []string{"tap deadbeef", "tap deadbeef 04a23b125c6180"},
+			events: []CardEvent{activated("deadbeef"), deactivated},
+		},
+		{
+			name:   "remove",
+			steps:  []string{"tap deadbeef", "remove"},
+			events: []CardEvent{activated("deadbeef"), deactivated},
+		},
+		{
+			name:   "card that is swapped before it settles is never read",
+			steps:  []string{"tap deadbeef\ntap 04a23b125c6180"},
+			events: []CardEvent{activated("04a23b125c6180")},
+		},
+		{
+			name:   "wait",
+			steps:  []string{"tap deadbeef\nwait 1ms"},
+			events: []CardEvent{activated("deadbeef")},
+		},
+		{
+			name:   "fail keeps the card",
+			steps:  []string{"tap deadbeef", "fail the antenna is loose\nremove"},
+			events: []CardEvent{activated("deadbeef")},
+			status: &failed,
+		},
+		{
+			name:   "recover",
+			steps:  []string{"tap deadbeef", "fail\nremove", "recover"},
+			events: []CardEvent{activated("deadbeef"), deactivated},
+			status: &ok,
+		},
+		{
+			name:   "comments and empty lines are skipped",
+			steps:  []string{"# put a card on\n\n   \ntap deadbeef"},
+			events: []CardEvent{activated("deadbeef")},
+		},
+		{
+			name:  "bad commands are told about",
+			steps: []string{"jump\ntap\nwait\nwait soon"},
+			out: "error: unknown command \"jump\"\n" +
+				"error: tap needs the ID of a card\n" +
+				"error: wait needs a duration, like 5s\n" +
+				"error: time: invalid duration \"soon\"\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &mockReader{status: make(chan ReaderStatus, 1), stop: make(chan interface{})}
+			var d debouncer
+			var events []CardEvent
+			var out strings.Builder
+			for _, step := range test.steps {
+				m.run(strings.NewReader(step), &out)
+				for i := 0; i <= debounceReads; i++ {
+					id, ok := m.read()
+					if !ok {
+						continue
+					}
+					if e, ok := d.read(id); ok {
+						events = append(events, e)
+					}
+				}
+			}
+
+			if len(events) > 0 || len(test.events) > 0 {
+				if !reflect.DeepEqual(events, test.events) {
+					t.Errorf("expected events %v, got %v", test.events, events)
+				}
+			}
+			select {
+			case s := <-m.status:
+				if test.status == nil {
+					t.Errorf("expected no status, got %+v", s)
+				} else if s.OK != *test.status || (s.Err == nil) != s.OK {
+					t.Errorf("expected the status to be OK %v, got %+v", *test.status, s)
+				}
+			default:
+				if test.status != nil {
+					t.Errorf("expected a status that is OK %v", *test.status)
+				}
+			}
+			if out.String() != test.out {
+				t.Errorf("expected the output %q, got %q", test.out, out.String())
+			}
+		})
+	}
+}
@@ -1,9 +1,25 @@
 package nfc
 
 import (
+	"errors"
+	"github.com/callebjorkell/rpi-nfc-player/logging"
 	"io"
+	"os"
+	"time"
 )
 
+var log = logging.For("nfc")
+
+// ErrNoHardware is returned when checking the reader of a build that isn't made for the Raspberry Pi.
+var ErrNoHardware = errors.New("not built for the Raspberry Pi")
+
+// MockSource is where the reader takes its commands from when not building for the Raspberry Pi: "-" for standard
+// input, "unix:<path>" for a Unix socket, or the path of a scenario file.
+var MockSource = "-"
+
+// MockInput is what a MockSource of "-" reads the commands from.
+var MockInput io.Reader = os.Stdin
+
 const (
 	Activated   CardState = 0
 	Deactivated CardState = 1
@@ -12,6 +28,16 @@ const (
 type CardReader interface {
 	io.Closer
 	Events() <-chan CardEvent
+	// Polled returns when the reader last looked for a card. It stops moving if the reader hangs.
+	Polled() time.Time
+	// Status gets a ReaderStatus when the reader stops answering, and when it answers again.
+	Status() <-chan ReaderStatus
+}
+
+// ReaderStatus tells if the reader works. Err is why it doesn't.
+type ReaderStatus struct {
+	OK  bool
+	Err error
 }
 
 type CardState int
@@ -19,3 +45,10 @@ type CardEvent struct {
 	CardID string
 	State  CardState
 }
+
+func (s CardState) String() string {
+	if s == Activated {
+		return "activated"
+	}
+	return "deactivated"
+}
@@ -1,5 +1,3 @@
-//+build pi
-
 package nfc
 
 import (
@@ -7,13 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/ecc1/spi"
 	"github.com/jdevelop/golang-rpi-extras/rf522/commands"
-	"github.com/jdevelop/gpio"
-	rpio "github.com/jdevelop/gpio/rpi"
-	log "github.com/sirupsen/logrus"
 )
 
 // MFRC522 spec can be found here: https://www.nxp.com/docs/en/data-sheet/MFRC522.pdf
@@ -25,29 +20,66 @@ import (
  * for MIFARE cards like the Ultralight that requires a L2 AntiColl to fetch the full ID.
  */
 
+const (
+	pollInterval = 150 * time.Millisecond
+	// healthCheckInterval is how often the version of the reader is read back, to notice when it stops answering. A
+	// reader that doesn't answer looks just like there is no card, so failed reads alone can't tell.
+	healthCheckInterval = 5 * time.Second
+	// maxReadFailures is how many reads in a row may fail, for other reasons than there being no card, before the
+	// reader is checked right away.
+	maxReadFailures = 10
+	// debounceReads is how many times in a row a card (or no card) has to be read, before it's believed.
+	debounceReads = 4
+)
+
 var (
 	NoCardErr = errors.New("no card detected")
 	stateLock sync.Mutex
 	active    bool
 )
 
+// spiConn is the SPI bus that the reader is on. Transfer sends the bytes in buf, and replaces them with the bytes that
+// were read back at the same time.
+type spiConn interface {
+	Transfer(buf []byte) error
+	Close() error
+}
+
+// outputPin is the pin connected to the NRSTPD pin of the reader. Pulling it low powers the reader down, and setting it
+// high again starts it up from scratch.
+type outputPin interface {
+	Set()
+	Clear()
+}
+
 type rfid struct {
-	ResetPin    gpio.Pin
+	ResetPin    outputPin
 	antennaGain int
 	MaxSpeedHz  int
-	spiDev      *spi.Device
+	spi         spiConn
 }
 
 type cardReader struct {
 	events <-chan CardEvent
+	status chan ReaderStatus
 	rfid   *rfid
 	stop   chan interface{}
+	// polled is when the polling loop last started a read, in Unix nanoseconds.
+	polled *atomic.Int64
 }
 
 func (c cardReader) Events() <-chan CardEvent {
 	return c.events
 }
 
+func (c cardReader) Status() <-chan ReaderStatus {
+	return c.status
+}
+
+func (c cardReader) Polled() time.Time {
+	return time.Unix(0, c.polled.Load())
+}
+
 func (c cardReader) Close() error {
 	close(c.stop)
 	defer func() {
@@ -58,86 +90,116 @@ func (c cardReader) Close() error {
 	return c.rfid.Close()
 }
 
-func CreateReader() (CardReader, error) {
-	stateLock.Lock()
-	if active {
-		return nil, errors.New("reader already in use")
-	} else {
-		active = true
-	}
-	stateLock.Unlock()
-
-	// the IRQ pin is actually connected on the board, but I could never get it to work properly. So now we're
-	// polling instead. Might come back to it at some point if I feel like losing another couple of days.
-	reader, err := makeRFID(0, 0, 100000, 22, 18)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+// newCardReader starts polling the reader for cards.
+func newCardReader(reader *rfid) cardReader {
 	events := make(chan CardEvent, 10)
 	c := cardReader{
 		rfid:   reader,
 		events: events,
+		status: make(chan ReaderStatus, 1),
 		stop:   make(chan interface{}),
+		polled: new(atomic.Int64),
 	}
+	c.polled.Store(time.Now().UnixNano())
+	go c.poll(events)
+	return c
+}
 
-	go func() {
-		defer close(events)
-		lastConfirmedId, lastSeenId := "", ""
-		debounceIndex := 0
-		for {
-			select {
-			case <-c.stop:
-				log.Debugln("CardReader stopped. Returning.")
-				return
-			case <-time.After(150 * time.Millisecond):
-				// after sleeping, DO WORK!
-			}
+func (c cardReader) poll(events chan<- CardEvent) {
+	defer close(events)
+	var d debouncer
+	healthy, failures, lastCheck := true, 0, time.Now()
+	for {
+		select {
+		case <-c.stop:
+			log.Debugln("CardReader stopped. Returning.")
+			return
+		case <-time.After(pollInterval):
+			// after sleeping, DO WORK!
+		}
+		c.polled.Store(time.Now().UnixNano())
+
+		if failures >= maxReadFailures || time.Since(lastCheck) >= healthCheckInterval {
+			healthy = checkHealth(c.rfid, healthy, c.status)
+			failures, lastCheck = 0, time.Now()
+		}
+		if !healthy {
+			continue
+		}
 
-			id, err := reader.readCardId()
-			if err != nil {
-				log.Debugf("error when reading card ID: %v", err)
+		id, err := c.rfid.readCardId()
+		if err != nil {
+			log.Debugf("error when reading card ID: %v", err)
+			if err != NoCardErr {
+				readErrorsTotal.Inc()
+				failures++
 			}
+		} else {
+			failures = 0
+		}
 
-			log.Debugf("ID: %v, lastSeen: %v, lastConfirmed: %v, debounce: %v", id, lastSeenId, lastConfirmedId, debounceIndex)
+		e, ok := d.read(id)
+		if !ok {
+			continue
+		}
+		if e.State == Deactivated {
+			// There is no card currently
+			log.Debugln("Sending deactivation event")
+		} else {
+			log.Debugf("Sending activation event for card %v", id)
+		}
+		events <- e
+		eventsTotal.WithLabelValues(e.State.String()).Inc()
+
+		if e.State == Activated {
+			// there seems to be some issues with reading sometimes. Not sure why that would be, but here we
+			// sleep as an extra countermeasure against "bounce". Since a card was just added, we might
+			// as well let it get going before reading again. Seems to help.
+			time.Sleep(1000 * time.Millisecond)
+		}
+	}
+}
 
-			if lastSeenId != id {
-				lastSeenId = id
-				debounceIndex = 0
-				continue
-			}
+// debouncer only believes a card, or that there is no card, once it has been read debounceReads times in a row. This
+// means there is a slight lag to start playing, but it also means it's way more stable when it actually does, in case
+// we have half reads, or multiple cards.
+type debouncer struct {
+	lastConfirmedId, lastSeenId string
+	debounceIndex               int
+}
 
-			if lastConfirmedId == id {
-				continue
-			}
+// read takes the ID of the card that was just read, or "" when there was no card, and returns the event to send if
+// the card on the reader has changed.
+func (d *debouncer) read(id string) (CardEvent, bool) {
+	log.Debugf("ID: %v, lastSeen: %v, lastConfirmed: %v, debounce: %v", id, d.lastSeenId, d.lastConfirmedId, d.debounceIndex)
 
-			// debounce the card, in case we have half reads, or multiple cards. This means there is a slight lag to
-			// start playing, but it also means it's way more stable when it actually does...
-			debounceIndex++
-			if debounceIndex >= 4 {
-				if id == "" {
-					// There is no card currently
-					log.Debugln("Sending deactivation event")
-					events <- CardEvent{State: Deactivated, CardID: ""}
-				} else {
-					log.Debugf("Sending activation event for card %v", id)
-					events <- CardEvent{State: Activated, CardID: id}
-
-					// there seems to be some issues with reading sometimes. Not sure why that would be, but here we
-					// sleep as an extra countermeasure against "bounce". Since a card was just added, we might
-					// as well let it get going before reading again. Seems to help.
-					time.Sleep(1000 * time.Millisecond)
-				}
-				lastConfirmedId = id
-				debounceIndex = 0
-			}
+	if d.lastSeenId != id {
+		if d.debounceIndex > 0 {
+			debounceFailuresTotal.Inc()
 		}
-	}()
-	return c, nil
+		d.lastSeenId = id
+		d.debounceIndex = 0
+		return CardEvent{}, false
+	}
+
+	if d.lastConfirmedId == id {
+		return CardEvent{}, false
+	}
+
+	d.debounceIndex++
+	if d.debounceIndex < debounceReads {
+		return CardEvent{}, false
+	}
+	d.lastConfirmedId = id
+	d.debounceIndex = 0
+	if id == "" {
+		return CardEvent{State: Deactivated, CardID: ""}, true
+	}
+	return CardEvent{State: Activated, CardID: id}, true
 }
 
 func (r *rfid) Close() error {
-	return r.spiDev.Close()
+	return r.spi.Close()
 }
 
 func (r *rfid) readCardId() (string, error) {
@@ -154,53 +216,6 @@ func (r *rfid) readCardId() (string, error) {
 	return hex.EncodeToString(data), nil
 }
 
-func makeRFID(busId, deviceId, maxSpeed, resetPin, irqPin int) (device *rfid, err error) {
-	spiDev, err := spi.Open(fmt.Sprintf("/dev/spidev%d.%d", busId, deviceId), maxSpeed, 0)
-
-	if err != nil {
-		return
-	}
-
-	err = spiDev.SetLSBFirst(false)
-	if err != nil {
-		spiDev.Close()
-		return
-	}
-
-	err = spiDev.SetBitsPerWord(8)
-
-	if err != nil {
-		spiDev.Close()
-		return
-	}
-
-	dev := &rfid{
-		spiDev:      spiDev,
-		MaxSpeedHz:  maxSpeed,
-		antennaGain: 7,
-	}
-
-	pin, err := rpio.OpenPin(resetPin, gpio.ModeOutput)
-	if err != nil {
-		spiDev.Close()
-		return
-	}
-	dev.ResetPin = pin
-	dev.ResetPin.Set()
-
-	pin, err = rpio.OpenPin(irqPin, gpio.ModeInput)
-	if err != nil {
-		spiDev.Close()
-		return
-	}
-
-	err = dev.init()
-
-	device = dev
-
-	return
-}
-
 func (r *rfid) init() (err error) {
 	err = r.reset()
 	if err != nil {
@@ -241,10 +256,39 @@ func (r *rfid) init() (err error) {
 	return
 }
 
+// version reads back the version of the reader. A reader that doesn't answer gives 0x00 or 0xff.
+func (r *rfid) version() (byte, error) {
+	version, err := r.devRead(commands.VersionReg)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0x00 || version == 0xff {
+		return version, fmt.Errorf("the reader answered with version %#02x", version)
+	}
+	return version, nil
+}
+
+func (r *rfid) checkVersion() error {
+	_, err := r.version()
+	return err
+}
+
+// hardReset resets the reader with its reset pin, which brings it back even when it doesn't answer to the soft reset,
+// and initializes it again.
+func (r *rfid) hardReset() error {
+	readerResetsTotal.Inc()
+	r.ResetPin.Clear()
+	time.Sleep(10 * time.Millisecond)
+	r.ResetPin.Set()
+	// the oscillator needs a moment to start up again.
+	time.Sleep(50 * time.Millisecond)
+	return r.init()
+}
+
 func (r *rfid) writeSpiData(dataIn []byte) (out []byte, err error) {
 	out = make([]byte, len(dataIn))
 	copy(out, dataIn)
-	err = r.spiDev.Transfer(out)
+	err = r.spi.Transfer(out)
 	return
 }
 
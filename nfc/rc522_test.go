@@ -0,0 +1,175 @@
+package nfc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	shortUid = []byte{0xde, 0xad, 0xbe, 0xef}
+	longUid  = []byte{0x04, 0xa2, 0x3b, 0x12, 0x5c, 0x61, 0x80}
+)
+
+func TestReadCardId(t *testing.T) {
+	tests := []struct {
+		name string
+		card []byte
+		id   string
+		err  error
+	}{
+		{name: "no card", err: NoCardErr},
+		{name: "4 byte UID", card: shortUid, id: "deadbeef"},
+		{name: "7 byte UID needs cascade level 2", card: longUid, id: "04a23b125c6180"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newEmulator()
+			r := newEmulatedReader(e)
+			e.card = test.card
+			// read twice, so that the card is known to answer again after being read.
+			for i := 0; i < 2; i++ {
+				id, err := r.readCardId()
+				if err != test.err {
+					t.Fatalf("expected error %v, got %v", test.err, err)
+				}
+				if id != test.id {
+					t.Errorf("expected ID %q, got %q", test.id, id)
+				}
+			}
+		})
+	}
+}
+
+func TestCrc(t *testing.T) {
+	tests := []struct {
+		data []byte
+		crc  []byte
+	}{
+		{data: []byte{0x00, 0x00}, crc: []byte{0xa0, 0x1e}},
+		{data: []byte{0x12, 0x34}, crc: []byte{0x26, 0xcf}},
+		// HALT
+		{data: []byte{0x50, 0x00}, crc: []byte{0x57, 0xcd}},
+	}
+	r := newEmulatedReader(newEmulator())
+	for _, test := range tests {
+		crc, err := r.crc(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(crc, test.crc) {
+			t.Errorf("expected CRC % x of % x, got % x", test.crc, test.data, crc)
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	activated := func(uid string) CardEvent {
+		return CardEvent{State: Activated, CardID: uid}
+	}
+	deactivated := CardEvent{State: Deactivated}
+	// repeat is the card read the given number of times in a row.
+	repeat := func(card []byte, n int) [][]byte {
+		reads := make([][]byte, n)
+		for i := range reads {
+			reads[i] = card
+		}
+		return reads
+	}
+	join := func(reads ...[][]byte) [][]byte {
+		var all [][]byte
+		for _, r := range reads {
+			all = append(all, r...)
+		}
+		return all
+	}
+
+	tests := []struct {
+		name string
+		// reads is the card on the reader for each read, nil for none.
+		reads  [][]byte
+		events []CardEvent
+	}{
+		{
+			name:  "nothing happens without a card",
+			reads: repeat(nil, 10),
+		},
+		{
+			name:   "card is activated once it has been read enough times",
+			reads:  repeat(shortUid, debounceReads+1),
+			events: []CardEvent{activated("deadbeef")},
+		},
+		{
+			name:  "card that isn't read enough times is ignored",
+			reads: repeat(shortUid, debounceReads),
+		},
+		{
+			name:  "a missed read starts the debounce over",
+			reads: join(repeat(longUid, debounceReads), repeat(nil, 1), repeat(longUid, debounceReads)),
+		},
+		{
+			name:   "card is deactivated once it's gone for long enough",
+			reads:  join(repeat(longUid, debounceReads+1), repeat(nil, debounceReads+1)),
+			events: []CardEvent{activated("04a23b125c6180"), deactivated},
+		},
+		{
+			name:   "card that is briefly missed stays activated",
+			reads:  join(repeat(longUid, debounceReads+1), repeat(nil, 2), repeat(longUid, debounceReads+1)),
+			events: []CardEvent{activated("04a23b125c6180")},
+		},
+		{
+			name:   "swapped cards are both activated",
+			reads:  join(repeat(shortUid, debounceReads+1), repeat(longUid, debounceReads+1)),
+			events: []CardEvent{activated("deadbeef"), activated("04a23b125c6180")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newEmulator()
+			r := newEmulatedReader(e)
+			var d debouncer
+			var events []CardEvent
+			for _, card := range test.reads {
+				e.card = card
+				id, _ := r.readCardId()
+				if ev, ok := d.read(id); ok {
+					events = append(events, ev)
+				}
+			}
+			if !reflect.DeepEqual(events, test.events) {
+				t.Errorf("expected events %v, got %v", test.events, events)
+			}
+		})
+	}
+}
+
+// TestHardReset checks that the emulated reader that hangs looks like there is no card, and that resetting it through
+// the reset pin gets the card read again.
+func TestHardReset(t *testing.T) {
+	e := newEmulator()
+	r := newEmulatedReader(e)
+	status := make(chan ReaderStatus, 1)
+	e.card = shortUid
+
+	e.hung = true
+	if _, err := r.readCardId(); err != NoCardErr {
+		t.Fatalf("expected a hung reader to look like there is no card, got %v", err)
+	}
+	if !checkHealth(r, true, status) {
+		t.Fatal("expected the reader to be reset")
+	}
+	if s := <-status; !s.OK {
+		t.Errorf("expected the reader to be fine after the reset, got %v", s)
+	}
+	if id, err := r.readCardId(); err != nil || id != "deadbeef" {
+		t.Errorf("expected the card to be read after the reset, got %q and %v", id, err)
+	}
+
+	e.broken = true
+	if checkHealth(r, true, status) {
+		t.Fatal("expected a broken reader to be unhealthy")
+	}
+	if s := <-status; s.OK || s.Err == nil {
+		t.Errorf("expected the reader to have failed, got %v", s)
+	}
+}
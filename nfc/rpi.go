@@ -0,0 +1,107 @@
+//go:build pi
+
+package nfc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ecc1/spi"
+	"github.com/jdevelop/gpio"
+	rpio "github.com/jdevelop/gpio/rpi"
+)
+
+func CreateReader() (CardReader, error) {
+	stateLock.Lock()
+	if active {
+		return nil, errors.New("reader already in use")
+	} else {
+		active = true
+	}
+	stateLock.Unlock()
+
+	// the IRQ pin is actually connected on the board, but I could never get it to work properly. So now we're
+	// polling instead. Might come back to it at some point if I feel like losing another couple of days.
+	reader, err := makeRFID(0, 0, 100000, 22, 18)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return newCardReader(reader), nil
+}
+
+// CheckReader initializes the reader, and reads back its version to check that it answers. A genuine MFRC522 answers
+// with 0x91 or 0x92, and clones often with something else that still isn't 0x00 or 0xff.
+func CheckReader() (byte, error) {
+	stateLock.Lock()
+	if active {
+		stateLock.Unlock()
+		return 0, errors.New("reader already in use")
+	}
+	active = true
+	stateLock.Unlock()
+	defer func() {
+		stateLock.Lock()
+		active = false
+		stateLock.Unlock()
+	}()
+
+	reader, err := makeRFID(0, 0, 100000, 22, 18)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	version, err := reader.version()
+	if err != nil && version != 0 {
+		return version, fmt.Errorf("%w, is it connected?", err)
+	}
+	return version, err
+}
+
+func makeRFID(busId, deviceId, maxSpeed, resetPin, irqPin int) (device *rfid, err error) {
+	spiDev, err := spi.Open(fmt.Sprintf("/dev/spidev%d.%d", busId, deviceId), maxSpeed, 0)
+
+	if err != nil {
+		return
+	}
+
+	err = spiDev.SetLSBFirst(false)
+	if err != nil {
+		spiDev.Close()
+		return
+	}
+
+	err = spiDev.SetBitsPerWord(8)
+
+	if err != nil {
+		spiDev.Close()
+		return
+	}
+
+	dev := &rfid{
+		spi:         spiDev,
+		MaxSpeedHz:  maxSpeed,
+		antennaGain: 7,
+	}
+
+	pin, err := rpio.OpenPin(resetPin, gpio.ModeOutput)
+	if err != nil {
+		spiDev.Close()
+		return
+	}
+	dev.ResetPin = pin
+	dev.ResetPin.Set()
+
+	pin, err = rpio.OpenPin(irqPin, gpio.ModeInput)
+	if err != nil {
+		spiDev.Close()
+		return
+	}
+
+	err = dev.init()
+
+	device = dev
+
+	return
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+func listPending() {
+	cards, err := db.ReadPending()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if len(cards) == 0 {
+		fmt.Println("No unknown cards have been put on the player lately.")
+		return
+	}
+	fmt.Println("            ID │ Seen")
+	fmt.Println("───────────────┼──────────────────────────")
+	for _, c := range cards {
+		ago := time.Since(c.Seen).Round(time.Second)
+		fmt.Printf("%14v │ %v (%v ago)\n", c.ID, c.Seen.Format("2006-01-02 15:04:05"), ago)
+	}
+	fmt.Println("\nThe newest card can be added with add --pending.")
+}
+
+// latestPending returns the id of the unknown card that was put on the player most recently.
+func latestPending() (string, error) {
+	cards, err := db.ReadPending()
+	if err != nil {
+		return "", err
+	}
+	if len(cards) == 0 {
+		return "", errors.New("no unknown cards have been put on the player lately")
+	}
+	return cards[0].ID, nil
+}
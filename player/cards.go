@@ -0,0 +1,336 @@
+package player
+
+import (
+	"errors"
+	"github.com/callebjorkell/rpi-nfc-player/nfc"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"strconv"
+)
+
+func (p *Player) handleCard(e nfc.CardEvent) {
+	timer := prometheus.NewTimer(cardHandleSeconds.WithLabelValues(e.State.String()))
+	defer timer.ObserveDuration()
+
+	if e.State == nfc.Activated {
+		if p.removal != nil {
+			// a card was put on while waiting for the last one to come back.
+			removal := *p.removal
+			p.removal = nil
+			if p.card != nil && e.CardID == p.card.ID {
+				log.Infof("Card %v came back, continuing", e.CardID)
+				return
+			}
+			p.cardRemoved(removal)
+		}
+		p.cardActivated(e.CardID)
+		return
+	}
+
+	if p.card == nil {
+		log.Debugf("Card %v removed, but no card was active", e.CardID)
+		return
+	}
+	if p.cfg.Grace > 0 && (p.state.active() || p.state == Paused) {
+		// small hands tend to nudge the card, so a removal only stops the music if the card doesn't come back within
+		// the grace period.
+		log.Debugf("Card removed, waiting %v for it to come back", p.cfg.Grace)
+		p.removal = &e
+		p.graceSeq++
+		p.after(p.cfg.Grace, graceExpired{seq: p.graceSeq})
+		return
+	}
+	p.cardRemoved(e)
+}
+
+func (p *Player) cardActivated(id string) {
+	log.Infof("Card %v activated", id)
+	p.seq++
+	p.latest.Store(int64(p.seq))
+	p.tapped = p.now()
+	p.blinks = 0
+	p.unknown = false
+	if p.capture != nil {
+		// someone is reading the card, so it's left alone when it's removed.
+		p.capture <- id
+		p.capture = nil
+		p.card = nil
+		p.flashLed(green)
+		return
+	}
+	if p.assigning != nil {
+		// the card is left alone when it's removed, and plays the next time it's put on.
+		p.assignCard(id)
+		p.card = nil
+		return
+	}
+
+	card, err := p.store.ReadCard(id)
+	if err != nil && !errors.Is(err, ErrUnknownCard) {
+		// the card may well be known, so it isn't remembered as one that isn't.
+		log.Error("Could not read the card: ", err)
+		p.card = &sonos.CardInfo{ID: id}
+		p.setState(Error)
+		return
+	}
+	if err != nil {
+		log.Info(err)
+		log.Infof("Remembering card %v, it can be added with add --pending", id)
+		p.card = &sonos.CardInfo{ID: id}
+		p.notify(Event{Type: EventUnknownCard, Card: id})
+		p.unknown = true
+		p.addPending(id)
+		p.setState(Error)
+		p.after(learnBlinkInterval, blink{seq: p.seq})
+		return
+	}
+	p.card = &card
+	p.notify(Event{Type: EventCardActivated, Card: id, Title: card.Title})
+
+	if card.Action != nil {
+		// control cards don't play anything, and leave the state alone.
+		p.runAction(card.Action)
+		p.flashLed(blue)
+		return
+	}
+
+	now := p.now()
+	used, err := p.store.ReadUsage(Day(now))
+	if err != nil {
+		log.Warn("Could not read today's usage: ", err)
+	}
+	if ok, reason := p.cfg.Rules.Allowed(now, used, p.override()); !ok {
+		log.Infof("Playing is not allowed right now: %v", reason)
+		p.lock()
+		return
+	}
+
+	p.stream = card.Stream
+	p.lastPlayed = card.ID
+	if err := p.store.StoreActive(card.ID); err != nil {
+		log.Warn("Could not store the active card: ", err)
+	}
+	p.setState(Loading)
+
+	// finding out what is on the speaker asks both the speaker and Deezer, so it's done next to the loop as well.
+	seq := p.seq
+	p.spawn(func() {
+		p.loads.Lock()
+		defer p.loads.Unlock()
+		if p.latest.Load() != int64(seq) {
+			log.Debugf("Not loading card %v, it has been taken off since", card.ID)
+			return
+		}
+		if p.speaker.HasLoaded(card) {
+			log.Debugf("Queue for card %v is already loaded", card.ID)
+			p.after(0, loaded{seq: seq, resume: true})
+			return
+		}
+		p.speaker.SetPlaylist(card)
+		// apparently this returns before the player is ready sometimes
+		p.after(loadSettle, loaded{seq: seq})
+	})
+}
+
+// startPlaying plays the card once it has been loaded onto the speaker.
+func (p *Player) startPlaying() {
+	if p.fresh {
+		p.speaker.Play()
+	} else {
+		p.speaker.Resume(*p.card)
+	}
+	p.applySleepTimer(*p.card)
+	p.setState(Playing)
+	tapToPlaySeconds.Observe(p.now().Sub(p.tapped).Seconds())
+
+	play := Play{CardID: p.card.ID, Title: p.card.Title, Time: p.now()}
+	if p.card.Stream != nil {
+		play.Title = p.card.Stream.Name
+	}
+	if err := p.store.AddPlay(play); err != nil {
+		log.Warn("Could not add the card to the history: ", err)
+	}
+}
+
+// lock stops the player from playing, and blinks the LED to show that the rules don't allow it.
+func (p *Player) lock() {
+	p.setState(Locked)
+	p.blinks = 6
+	p.after(blinkInterval, blink{seq: p.seq})
+}
+
+func (p *Player) cardRemoved(e nfc.CardEvent) {
+	log.Infof("Card %v removed...", e.CardID)
+	card := p.card
+	p.seq++
+	p.latest.Store(int64(p.seq))
+	p.card = nil
+	p.blinks = 0
+	p.unknown = false
+
+	if card == nil {
+		return
+	}
+	p.notify(Event{Type: EventCardRemoved, Card: card.ID, Title: card.Title})
+	if card.Action != nil {
+		return
+	}
+	if p.state.active() || p.state == Paused || p.speakerLost {
+		if (p.state == Playing || p.state == Paused) && p.stream == nil {
+			// radio streams have no state worth saving, and a queue that is still loading has no new position.
+			p.saveState(card.ID)
+		}
+		p.speaker.Pause()
+		p.endFade()
+	}
+	if err := p.store.StoreActive(""); err != nil {
+		log.Warn("Could not clear the active card: ", err)
+	}
+	p.stream = nil
+	p.speakerLost = false
+	p.setState(Idle)
+}
+
+// saveState stores the current position of the speaker on the given card, so that playback can be resumed from there
+// the next time the card is put on.
+func (p *Player) saveState(cardId string) {
+	state, err := p.speaker.MediaInfo()
+	if err != nil {
+		log.Warn("Could not fetch the player state to save it ", err)
+		return
+	}
+	c, err := p.store.ReadCard(cardId)
+	if err != nil || !c.Resumable() {
+		return
+	}
+
+	i, err := strconv.Atoi(state.Track)
+	if err != nil {
+		log.Warnf("Could not parse current track: %v", err.Error())
+		i = 1
+	}
+
+	c.State = &sonos.CardStatus{
+		CurrentTrack:    i,
+		CurrentPosition: state.RelTime,
+	}
+
+	if err := p.store.StoreCard(&c); err != nil {
+		log.Warn("Could not update playlist state: ", err)
+	} else {
+		log.Debugf("Updated card %v with state %v", cardId, c.State)
+	}
+}
+
+// runAction carries out what a control card asks for. The queue is left alone, and no state is saved.
+func (p *Player) runAction(a *sonos.Action) {
+	log.Infof("Running action: %v", a.Title())
+	switch a.Type {
+	case sonos.ShuffleAction:
+		p.speaker.SetShuffle(a.Value == "on")
+	case sonos.VolumeAction:
+		change, err := a.VolumeChange()
+		if err != nil {
+			log.Warn(err)
+			return
+		}
+		v, err := p.speaker.Volume()
+		if err != nil {
+			log.Warn("Could not read the volume: ", err)
+			return
+		}
+		v += change
+		if v < 0 {
+			v = 0
+		} else if v > 100 {
+			v = 100
+		}
+		if err := p.speaker.SetVolume(v); err != nil {
+			log.Warn("Could not set the volume: ", err)
+		}
+	case sonos.ResetAction:
+		p.speaker.Stop()
+		if p.lastPlayed == "" {
+			return
+		}
+		c, err := p.store.ReadCard(p.lastPlayed)
+		if err != nil {
+			log.Warn(err)
+			return
+		}
+		c.State = nil
+		if err := p.store.StoreCard(&c); err != nil {
+			log.Warn("Could not reset the card state: ", err)
+		} else {
+			log.Infof("Reset the state of card %v", c.ID)
+		}
+	case sonos.SleepAction:
+		d, err := a.SleepDuration()
+		if err != nil {
+			log.Warn(err)
+			return
+		}
+		if err := p.speaker.ConfigureSleepTimer(d); err != nil {
+			log.Warn("Could not set the sleep timer: ", err)
+		}
+	case sonos.SpeakerAction:
+		// finding the speaker takes a while, and waits for the card that is loading, so that its queue isn't set up
+		// across two zones.
+		name := a.Value
+		p.spawn(func() {
+			p.loads.Lock()
+			defer p.loads.Unlock()
+			if err := p.speaker.Switch(name); err != nil {
+				log.Warn("Could not switch speaker: ", err)
+			} else {
+				log.Infof("Now playing on %v", p.speaker.Name())
+			}
+		})
+	default:
+		log.Warnf("Unknown action %v", a.Type)
+	}
+}
+
+// cycleStation switches to another one of the configured radio stations, or of the stations that have been put on
+// cards, ordered by name, when none are configured. step decides how many stations to move (and in which direction).
+func (p *Player) cycleStation(step int) {
+	stations := p.cfg.Stations
+	if len(stations) == 0 {
+		cards, err := p.store.ReadAll()
+		if err != nil {
+			log.Warn("Could not read the radio stations: ", err)
+			return
+		}
+		for _, c := range *cards {
+			if c.Stream != nil {
+				stations = append(stations, *c.Stream)
+			}
+		}
+		sort.Slice(stations, func(i, j int) bool {
+			return stations[i].Name < stations[j].Name
+		})
+	}
+	if len(stations) == 0 {
+		return
+	}
+
+	// a station that isn't in the list moves to the first one, or the last one when going backwards.
+	current := len(stations)
+	if step > 0 {
+		current = -1
+	}
+	for i, st := range stations {
+		if st.URL == p.stream.URL {
+			current = i
+			break
+		}
+	}
+	next := ((current+step)%len(stations) + len(stations)) % len(stations)
+
+	station := stations[next]
+	log.Infof("Switching to station %v", station.Name)
+	p.stream = &station
+	p.speaker.PlayStream(*p.stream)
+	p.speaker.Play()
+}
@@ -0,0 +1,263 @@
+package player
+
+import (
+	"errors"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"github.com/callebjorkell/rpi-nfc-player/ui"
+	"reflect"
+	"time"
+)
+
+// Command is something the player can be asked to do from outside, like from the HTTP API.
+type Command string
+
+const (
+	CommandPlay     Command = "play"
+	CommandPause    Command = "pause"
+	CommandNext     Command = "next"
+	CommandPrevious Command = "previous"
+)
+
+const (
+	// assignTimeout is how long the player waits for a card to assign to, before giving up.
+	assignTimeout = 2 * time.Minute
+	replyTimeout  = 10 * time.Second
+)
+
+var (
+	ErrNothingToPlay  = errors.New("there is no card on the lid")
+	ErrNotPlaying     = errors.New("nothing is playing")
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrNoReply        = errors.New("the player did not reply in time")
+)
+
+// commandVolume sets the volume of the speaker to the value of the command.
+const commandVolume Command = "volume"
+
+type command struct {
+	cmd   Command
+	value int
+	reply chan error
+}
+
+type assign struct {
+	card  *sonos.CardInfo
+	reply chan error
+}
+
+type assignExpired struct {
+	seq int
+}
+
+// Status is a snapshot of what the player is doing.
+type Status struct {
+	State State `json:"state"`
+	// Since is when the player moved to the state.
+	Since time.Time `json:"since"`
+	// Card is the card on the lid, if any.
+	Card *sonos.CardInfo `json:"card,omitempty"`
+	// LastPlayed is the ID of the last card that played music.
+	LastPlayed string `json:"lastPlayed,omitempty"`
+	// Assigning is the card that the next card put on the lid will be assigned to, if any.
+	Assigning *sonos.CardInfo `json:"assigning,omitempty"`
+	// Assigned is the ID of the card that was last assigned.
+	Assigned string `json:"assigned,omitempty"`
+	// Pending are the unknown cards that have been put on the lid lately, newest first.
+	Pending    []PendingCard `json:"pending"`
+	TigerArmed bool          `json:"tigerArmed"`
+	// Led is the color that the LED shows, and Volume the last seen volume of the speaker.
+	Led    string `json:"led"`
+	Volume int    `json:"volume"`
+	// ReaderFailed is set while the card reader isn't answering.
+	ReaderFailed bool `json:"readerFailed"`
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Command asks the player to do something, and waits for it to be done.
+func (p *Player) Command(c Command) error {
+	return p.run(command{cmd: c})
+}
+
+// SetVolume sets the volume of the speaker, between 0 and 100.
+func (p *Player) SetVolume(volume int) error {
+	return p.run(command{cmd: commandVolume, value: volume})
+}
+
+// SetTiger arms or disarms the tiger, just like the tiger switch.
+func (p *Player) SetTiger(armed bool) error {
+	return p.send(ui.ButtonEvent{Button: ui.TigerSwitch, Pressed: armed})
+}
+
+// send hands an event to the player, giving up if the player doesn't take it in time.
+func (p *Player) send(e interface{}) error {
+	select {
+	case p.events <- e:
+		return nil
+	case <-time.After(replyTimeout):
+		return ErrNoReply
+	}
+}
+
+func (p *Player) run(c command) error {
+	reply := make(chan error, 1)
+	c.reply = reply
+	if err := p.send(c); err != nil {
+		return err
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-time.After(replyTimeout):
+		return ErrNoReply
+	}
+}
+
+// Assign makes the player store the given card on the next card that is put on the lid, instead of playing it. The
+// ID of the card is filled in then. A nil card cancels an assignment that is waiting for a card.
+func (p *Player) Assign(card *sonos.CardInfo) error {
+	reply := make(chan error, 1)
+	if err := p.send(assign{card: card, reply: reply}); err != nil {
+		return err
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-time.After(replyTimeout):
+		return ErrNoReply
+	}
+}
+
+// Status returns what the player is doing right now.
+func (p *Player) Status() Status {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	return p.status
+}
+
+// Watch returns a channel that gets the status of the player whenever it changes. Only the latest status is kept, so
+// a slow reader only misses the changes in between.
+func (p *Player) Watch() <-chan Status {
+	ch := make(chan Status, 1)
+	p.statusLock.Lock()
+	p.watchers = append(p.watchers, ch)
+	p.statusLock.Unlock()
+	return ch
+}
+
+func (p *Player) updateStatus() {
+	s := Status{
+		State:        p.state,
+		Since:        p.since,
+		LastPlayed:   p.lastPlayed,
+		Assigned:     p.assigned,
+		Pending:      append([]PendingCard{}, p.pending...),
+		TigerArmed:   p.tigerArmed,
+		Led:          p.ledStatus(),
+		Volume:       p.volume,
+		ReaderFailed: p.readerFailed,
+	}
+	if p.card != nil {
+		c := *p.card
+		s.Card = &c
+	}
+	if p.assigning != nil {
+		c := *p.assigning
+		s.Assigning = &c
+	}
+
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	if reflect.DeepEqual(s, p.status) {
+		return
+	}
+	p.status = s
+	for _, ch := range p.watchers {
+		select {
+		case ch <- s:
+		default:
+			// drop the status that hasn't been read, in favor of the new one.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- s
+		}
+	}
+}
+
+func (p *Player) runCommand(c command) {
+	log.Infof("Running command %v", c.cmd)
+	var err error
+	switch c.cmd {
+	case CommandPlay:
+		switch p.state {
+		case Paused:
+			p.speaker.Play()
+			p.setState(Playing)
+		case Playing, Loading:
+		default:
+			err = ErrNothingToPlay
+		}
+	case CommandPause:
+		switch p.state {
+		case Playing:
+			p.speaker.Pause()
+			p.saveActive()
+			p.setState(Paused)
+		case Paused:
+		default:
+			err = ErrNotPlaying
+		}
+	case commandVolume:
+		v := c.value
+		if v < 0 {
+			v = 0
+		} else if v > 100 {
+			v = 100
+		}
+		if err = p.speaker.SetVolume(v); err == nil {
+			p.volume = v
+		}
+	case CommandNext, CommandPrevious:
+		if p.state != Playing {
+			err = ErrNotPlaying
+		} else if c.cmd == CommandNext {
+			p.skip(1)
+		} else {
+			p.skip(-1)
+		}
+	default:
+		err = ErrUnknownCommand
+	}
+	c.reply <- err
+}
+
+func (p *Player) startAssign(a assign) {
+	defer close(a.reply)
+	p.assigning = a.card
+	p.assignSeq++
+	if a.card == nil {
+		log.Info("Cancelled card assignment")
+		return
+	}
+	log.Infof("Waiting for a card to assign %v to", a.card.Title)
+	p.after(assignTimeout, assignExpired{seq: p.assignSeq})
+}
+
+// assignCard stores the card that is waiting to be assigned on the given card ID.
+func (p *Player) assignCard(id string) {
+	c := *p.assigning
+	p.assigning = nil
+	c.ID = id
+	if err := p.store.StoreCard(&c); err != nil {
+		log.Error("Could not assign the card: ", err)
+		p.flashLed(yellow)
+		return
+	}
+	log.Infof("Assigned %v to card %v", c.Title, id)
+	p.assigned = id
+	p.flashLed(green)
+}
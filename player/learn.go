@@ -0,0 +1,117 @@
+package player
+
+import (
+	"errors"
+	"github.com/callebjorkell/rpi-nfc-player/nfc"
+	"time"
+)
+
+const (
+	// maxPending is how many unknown cards are remembered.
+	maxPending         = 10
+	learnBlinkInterval = 500 * time.Millisecond
+)
+
+var ErrNoCard = errors.New("no card was put on")
+
+// PendingCard is a card that was put on the lid without having been added first.
+type PendingCard struct {
+	ID   string    `json:"id"`
+	Seen time.Time `json:"seen"`
+}
+
+type (
+	cardAdded struct {
+		id string
+	}
+	cardDeleted struct {
+		id string
+	}
+)
+
+type capture struct {
+	reply chan string
+}
+
+// CardAdded tells the player that a card has been added to the database while it's running. The card is no longer
+// pending, and if it's on the lid it starts playing right away.
+func (p *Player) CardAdded(id string) error {
+	return p.send(cardAdded{id: id})
+}
+
+// CardDeleted tells the player that a card has been deleted from the database while it's running. If it's the card on
+// the lid, it stops playing, so that nothing is resumed from a card that is gone.
+func (p *Player) CardDeleted(id string) error {
+	return p.send(cardDeleted{id: id})
+}
+
+// ReadCard waits for the next card that is put on the lid, and returns its ID instead of playing it.
+func (p *Player) ReadCard(timeout time.Duration) (string, error) {
+	reply := make(chan string, 1)
+	if err := p.send(capture{reply: reply}); err != nil {
+		return "", err
+	}
+	select {
+	case id := <-reply:
+		return id, nil
+	case <-time.After(timeout):
+		if err := p.send(capture{}); err != nil {
+			return "", err
+		}
+		// the card might have come in just as the time ran out.
+		select {
+		case id := <-reply:
+			return id, nil
+		default:
+			return "", ErrNoCard
+		}
+	}
+}
+
+// addPending remembers a card that isn't known, so that it can be added later on.
+func (p *Player) addPending(id string) {
+	pending := []PendingCard{{ID: id, Seen: p.now()}}
+	for _, c := range p.pending {
+		if c.ID != id && len(pending) < maxPending {
+			pending = append(pending, c)
+		}
+	}
+	p.setPending(pending)
+}
+
+func (p *Player) setPending(pending []PendingCard) {
+	p.pending = pending
+	if err := p.store.StorePending(pending); err != nil {
+		log.Warn("Could not store the unknown cards: ", err)
+	}
+}
+
+func (p *Player) cardAdded(id string) {
+	log.Infof("Card %v was added", id)
+	var pending []PendingCard
+	for _, c := range p.pending {
+		if c.ID != id {
+			pending = append(pending, c)
+		}
+	}
+	if len(pending) != len(p.pending) {
+		p.setPending(pending)
+	}
+
+	if p.unknown && p.card != nil && p.card.ID == id {
+		p.cardActivated(id)
+	}
+}
+
+func (p *Player) cardDeleted(id string) {
+	if p.lastPlayed == id {
+		p.lastPlayed = ""
+	}
+	if p.unknown || p.card == nil || p.card.ID != id {
+		return
+	}
+	log.Infof("Card %v was deleted, stopping it", id)
+	// the card stays on the lid, but counts as gone, so a removal that is waiting for it is dropped.
+	p.removal = nil
+	p.cardRemoved(nfc.CardEvent{CardID: id, State: nfc.Deactivated})
+}
@@ -0,0 +1,19 @@
+package player
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cardHandleSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "player_card_handle_seconds",
+		Help:    "How long the player takes to handle a card being put on or taken off the lid.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"state"})
+	tapToPlaySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "player_tap_to_play_seconds",
+		Help:    "Time from a card being put on the lid until its music starts playing.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 3, 5, 8, 13, 20},
+	})
+)
@@ -0,0 +1,78 @@
+package player
+
+import (
+	"time"
+)
+
+// EventType is the kind of thing that happened to the player, as told to the ones listening on Events.
+type EventType string
+
+const (
+	EventCardActivated   EventType = "card-activated"
+	EventCardRemoved     EventType = "card-removed"
+	EventUnknownCard     EventType = "unknown-card"
+	EventButton          EventType = "button"
+	EventTigerArmed      EventType = "tiger-armed"
+	EventTigerDisarmed   EventType = "tiger-disarmed"
+	EventSpeakerError    EventType = "speaker-error"
+	EventReaderError     EventType = "reader-error"
+	EventReaderRecovered EventType = "reader-recovered"
+)
+
+// EventTypes are all the types of events, in the order they are documented.
+var EventTypes = []EventType{
+	EventCardActivated, EventCardRemoved, EventUnknownCard, EventButton, EventTigerArmed, EventTigerDisarmed,
+	EventSpeakerError, EventReaderError, EventReaderRecovered,
+}
+
+// eventBuffer is how many events a listener may fall behind before events are dropped.
+const eventBuffer = 20
+
+// Event is something that happened to the player. Only the fields that make sense for the type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// State is the state of the player after the event.
+	State  State  `json:"state"`
+	Card   string `json:"card,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Button string `json:"button,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Events returns a channel that gets everything that happens to the player. The player never waits for the channel,
+// so events are dropped if it isn't read in time.
+func (p *Player) Events() <-chan Event {
+	ch := make(chan Event, eventBuffer)
+	p.statusLock.Lock()
+	p.listeners = append(p.listeners, ch)
+	p.statusLock.Unlock()
+	return ch
+}
+
+// notify tells the listeners about an event. The state is filled in when the event has been handled, since that is
+// when the state is known.
+func (p *Player) notify(e Event) {
+	e.Time = p.now()
+	p.notified = append(p.notified, e)
+}
+
+// sendEvents sends the events that were noticed while handling the last event.
+func (p *Player) sendEvents() {
+	if len(p.notified) == 0 {
+		return
+	}
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	for _, e := range p.notified {
+		e.State = p.state
+		for _, ch := range p.listeners {
+			select {
+			case ch <- e:
+			default:
+				log.Warnf("Dropping the %v event, nobody is listening", e.Type)
+			}
+		}
+	}
+	p.notified = p.notified[:0]
+}
@@ -0,0 +1,525 @@
+package player
+
+import (
+	"github.com/callebjorkell/rpi-nfc-player/logging"
+	"github.com/callebjorkell/rpi-nfc-player/nfc"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"github.com/callebjorkell/rpi-nfc-player/ui"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var log = logging.For("player")
+
+const (
+	tickInterval = 5 * time.Second
+	// loadSettle is how long to wait after loading a queue before playing it, since the speaker sometimes reports
+	// that it's done before it's ready.
+	loadSettle = 750 * time.Millisecond
+	// flashDuration is how long the LED flashes when a button is pressed or a control card is put on.
+	flashDuration = 400 * time.Millisecond
+	blinkInterval = 200 * time.Millisecond
+	// speakerSettle is how long the speaker may report being stopped after playback was started, without the player
+	// considering it paused.
+	speakerSettle = 3 * time.Second
+)
+
+// Config holds the settings of the player.
+type Config struct {
+	// Grace is how long a card may be away from the lid before the music stops.
+	Grace time.Duration
+	// Bedtime is the time since midnight after which BedtimeSleep is applied to anything that plays. Negative if
+	// there is no bedtime.
+	Bedtime      time.Duration
+	BedtimeSleep time.Duration
+	// SleepFade is how long the volume is faded out before a sleep timer stops the music.
+	SleepFade time.Duration
+	Rules     Rules
+	// Stations are the radio stations that the buttons cycle through while radio plays. Without any, the stations on
+	// the cards are cycled through instead.
+	Stations []radio.Station
+}
+
+// the events that the player sends to itself.
+type (
+	// loaded is sent once the queue of the card is on the speaker. resume is set when it already was, so that it's
+	// picked up where it was left.
+	loaded struct {
+		seq    int
+		resume bool
+	}
+	graceExpired struct {
+		seq int
+	}
+	flashDone struct {
+		seq int
+	}
+	blink struct {
+		seq int
+	}
+	fadeStep struct {
+		seq int
+	}
+	tick struct{}
+)
+
+// Player plays the cards that are put on the lid on a speaker. Everything that happens is handled as an event on a
+// single loop, which moves the player between its states and keeps the LED and the tiger in line with them.
+type Player struct {
+	speaker Speaker
+	store   Store
+	led     ui.ColorLed
+	tiger   ui.Tiger
+	cfg     Config
+
+	events chan interface{}
+	now    func() time.Time
+	after  func(d time.Duration, e interface{})
+	spawn  func(f func())
+
+	state State
+	since time.Time
+	// seq is bumped every time a card comes or goes, so that events that were started for an earlier card can be
+	// recognized and dropped.
+	seq int
+	// loads makes the speaker load one card at a time, and latest is seq for the loads, so that a load for a card that
+	// is gone by the time that it's its turn is skipped.
+	loads  sync.Mutex
+	latest atomic.Int64
+	// card is the card on the lid, and lastPlayed the last card that played music.
+	card       *sonos.CardInfo
+	lastPlayed string
+	stream     *radio.Station
+	fresh      bool
+	started    time.Time
+	// tapped is when the card on the lid was put on.
+	tapped time.Time
+
+	tigerArmed bool
+	flash      *color
+	flashSeq   int
+	blinks     int
+	// removal is the removal of the card that is waiting for the grace period to run out.
+	removal  *nfc.CardEvent
+	graceSeq int
+	// speakerLost is set when the player is in the error state because the speaker could not be reached, and unknown
+	// when it's because the card on the lid hasn't been added.
+	speakerLost bool
+	unknown     bool
+	// readerFailed is set while the card reader isn't answering, so that no cards can be read.
+	readerFailed bool
+	pending      []PendingCard
+	// capture is where the ID of the next card that is put on goes, when someone is waiting to read a card.
+	capture chan string
+
+	counted     time.Time
+	lastBedtime string
+	fade        *fade
+
+	// assigning is the card that the next card on the lid is assigned to, and assigned the last card that was.
+	assigning *sonos.CardInfo
+	assignSeq int
+	assigned  string
+
+	volume int
+
+	status     Status
+	statusLock sync.Mutex
+	watchers   []chan Status
+	listeners  []chan Event
+	// notified are the events to send to the listeners once the event that is being handled is done.
+	notified []Event
+
+	// alive is when the event loop last handled an event, in Unix nanoseconds. The ticker makes sure that this
+	// happens every tickInterval when the loop is healthy.
+	alive atomic.Int64
+
+	// what the LED and the tiger were last set to, so that they are only touched when something changes.
+	shownLed   *color
+	shownTiger *bool
+}
+
+// New creates a player for the given speaker. It does nothing until Run is called.
+func New(speaker Speaker, store Store, led ui.ColorLed, tiger ui.Tiger, cfg Config) *Player {
+	p := &Player{
+		speaker: speaker,
+		store:   store,
+		led:     led,
+		tiger:   tiger,
+		cfg:     cfg,
+		events:  make(chan interface{}, 10),
+		now:     time.Now,
+		spawn: func(f func()) {
+			go f()
+		},
+	}
+	p.after = func(d time.Duration, e interface{}) {
+		time.AfterFunc(d, func() {
+			p.events <- e
+		})
+	}
+	return p
+}
+
+// Run handles the events from the card reader, the buttons and the speaker until the card reader is closed.
+func (p *Player) Run(cards <-chan nfc.CardEvent, reader <-chan nfc.ReaderStatus, buttons <-chan ui.ButtonEvent,
+	speaker <-chan SpeakerEvent) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	p.since = p.now()
+	p.alive.Store(time.Now().UnixNano())
+	if pending, err := p.store.ReadPending(); err != nil {
+		log.Warn("Could not read the unknown cards: ", err)
+	} else {
+		p.pending = pending
+	}
+	p.show()
+	p.updateStatus()
+	for {
+		select {
+		case c, ok := <-cards:
+			if !ok {
+				log.Info("Card reader has closed, stopping the player.")
+				return
+			}
+			p.handle(c)
+		case r := <-reader:
+			p.handle(r)
+		case b, ok := <-buttons:
+			if !ok {
+				log.Error("Button channel has closed.")
+				buttons = nil
+				continue
+			}
+			p.handle(b)
+		case e := <-speaker:
+			p.handle(e)
+		case e := <-p.events:
+			p.handle(e)
+		case <-ticker.C:
+			p.handle(tick{})
+		}
+	}
+}
+
+func (p *Player) handle(e interface{}) {
+	switch e := e.(type) {
+	case nfc.CardEvent:
+		p.handleCard(e)
+	case nfc.ReaderStatus:
+		p.handleReader(e)
+	case ui.ButtonEvent:
+		p.handleButton(e)
+	case SpeakerEvent:
+		p.handleSpeaker(e)
+	case loaded:
+		if e.seq == p.seq && p.state == Loading {
+			p.fresh = !e.resume
+			p.startPlaying()
+		}
+	case graceExpired:
+		if e.seq == p.graceSeq && p.removal != nil {
+			removal := *p.removal
+			p.removal = nil
+			p.cardRemoved(removal)
+		}
+	case flashDone:
+		if e.seq == p.flashSeq {
+			p.flash = nil
+		}
+	case blink:
+		if e.seq != p.seq {
+			break
+		}
+		if p.unknown {
+			// keeps blinking for as long as the card is on the lid.
+			p.blinks++
+			p.after(learnBlinkInterval, blink{seq: p.seq})
+		} else if p.blinks > 0 {
+			p.blinks--
+			if p.blinks > 0 {
+				p.after(blinkInterval, blink{seq: p.seq})
+			}
+		}
+	case fadeStep:
+		p.fadeStep(e)
+	case tick:
+		p.tick()
+	case command:
+		p.runCommand(e)
+	case assign:
+		p.startAssign(e)
+	case cardAdded:
+		p.cardAdded(e.id)
+	case cardDeleted:
+		p.cardDeleted(e.id)
+	case capture:
+		p.capture = e.reply
+	case assignExpired:
+		if e.seq == p.assignSeq && p.assigning != nil {
+			log.Info("No card was put on, giving up the card assignment")
+			p.assigning = nil
+		}
+	default:
+		log.Warnf("Unknown player event %T", e)
+	}
+	p.show()
+	p.updateStatus()
+	p.sendEvents()
+	p.alive.Store(time.Now().UnixNano())
+}
+
+// Alive returns when the event loop last handled an event. It stops moving if the loop hangs.
+func (p *Player) Alive() time.Time {
+	return time.Unix(0, p.alive.Load())
+}
+
+// setState moves the player to a new state, keeping track of how long has been played.
+func (p *Player) setState(s State) {
+	if s == p.state {
+		return
+	}
+	log.Debugf("Player %v -> %v", p.state, s)
+	if p.state == Playing {
+		p.countUsage()
+	}
+	if s == Playing {
+		p.started = p.now()
+		p.counted = p.started
+	}
+	p.state = s
+	p.since = p.now()
+}
+
+func (p *Player) handleReader(r nfc.ReaderStatus) {
+	if r.OK && p.readerFailed {
+		log.Info("The card reader works again")
+		p.notify(Event{Type: EventReaderRecovered})
+	} else if !r.OK && !p.readerFailed {
+		log.Warn("The card reader has failed: ", r.Err)
+		e := Event{Type: EventReaderError}
+		if r.Err != nil {
+			e.Error = r.Err.Error()
+		}
+		p.notify(e)
+	}
+	p.readerFailed = !r.OK
+}
+
+func (p *Player) handleButton(b ui.ButtonEvent) {
+	log.Debugln(b)
+	switch b.Button {
+	case ui.TigerSwitch:
+		if b.Pressed && !p.tigerArmed {
+			p.notify(Event{Type: EventTigerArmed})
+		} else if !b.Pressed && p.tigerArmed {
+			p.notify(Event{Type: EventTigerDisarmed})
+		}
+		p.tigerArmed = b.Pressed
+	case ui.Red:
+		if b.Pressed {
+			p.notify(Event{Type: EventButton, Button: b.Button.String()})
+		}
+		if b.Pressed && p.state == Playing {
+			p.skip(-1)
+		}
+	case ui.Blue:
+		if b.Pressed {
+			p.notify(Event{Type: EventButton, Button: b.Button.String()})
+		}
+		if b.Pressed && p.state == Playing {
+			p.skip(1)
+		}
+	}
+}
+
+// skip moves to the next track when step is positive, or the previous one when it's negative. Radio streams move to
+// another station instead.
+func (p *Player) skip(step int) {
+	if step > 0 {
+		p.flashLed(cyan)
+	} else {
+		p.flashLed(yellow)
+	}
+	if p.stream != nil {
+		p.cycleStation(step)
+	} else if step > 0 {
+		p.speaker.Next()
+	} else {
+		p.speaker.Previous()
+	}
+}
+
+func (p *Player) handleSpeaker(e SpeakerEvent) {
+	if e.Err == nil {
+		p.volume = e.Volume
+	} else {
+		log.Debug("Could not read the state of the speaker: ", e.Err)
+		if p.state == Playing || p.state == Paused {
+			log.Warn("Lost contact with the speaker")
+			p.notify(Event{Type: EventSpeakerError, Card: p.card.ID, Title: p.card.Title, Error: e.Err.Error()})
+			p.endFade()
+			p.speakerLost = true
+			p.setState(Error)
+		}
+		return
+	}
+
+	switch p.state {
+	case Playing:
+		if e.State == TransportPaused || e.State == TransportStopped {
+			if p.now().Sub(p.started) < speakerSettle {
+				return
+			}
+			log.Info("Speaker stopped playing")
+			p.setState(Paused)
+		}
+	case Paused:
+		if e.State == TransportPlaying {
+			log.Info("Speaker started playing again")
+			p.setState(Playing)
+		}
+	case Error:
+		if !p.speakerLost {
+			return
+		}
+		// the speaker is back, so pick up where it is.
+		log.Info("Speaker is reachable again")
+		p.speakerLost = false
+		if e.State == TransportPlaying {
+			p.setState(Playing)
+		} else {
+			p.setState(Paused)
+		}
+	}
+}
+
+// flashLed shows a color on the LED for a moment, before going back to what the state says.
+func (p *Player) flashLed(c color) {
+	p.flashSeq++
+	p.flash = &c
+	p.after(flashDuration, flashDone{seq: p.flashSeq})
+}
+
+// show sets the LED and the tiger to what the state of the player says. A flash takes precedence over everything
+// else, and the tiger is let loose whenever it's armed and nothing is playing.
+func (p *Player) show() {
+	tiger := p.tigerArmed && !p.state.active()
+	if p.shownTiger == nil || *p.shownTiger != tiger {
+		if tiger {
+			log.Info("Tiger switched on, enabling tiger.")
+			p.tiger.On()
+		} else {
+			p.tiger.Off()
+		}
+		p.shownTiger = &tiger
+	}
+
+	c := p.ledColor(tiger)
+	if p.flash != nil {
+		c = *p.flash
+	}
+	if p.shownLed == nil || *p.shownLed != c {
+		c.set(p.led)
+		p.shownLed = &c
+	}
+}
+
+func (p *Player) ledColor(tiger bool) color {
+	if tiger {
+		return red
+	}
+	if p.readerFailed {
+		// nothing can be done with the cards until the reader is back, whatever the state.
+		return yellow
+	}
+	switch p.state {
+	case Loading:
+		return purple
+	case Playing:
+		return green
+	case Paused:
+		return blue
+	case Error:
+		if p.unknown {
+			if p.blinks%2 == 0 {
+				return cyan
+			}
+			return off
+		}
+		return yellow
+	case Locked:
+		if p.blinks > 0 && p.blinks%2 == 0 {
+			return red
+		}
+	}
+	return off
+}
+
+// ledStatus describes what the LED shows. Blinking is described as such, so that the status doesn't change with
+// every blink.
+func (p *Player) ledStatus() string {
+	switch {
+	case p.flash != nil:
+		return p.flash.String()
+	case p.state == Error && p.unknown && !p.tigerArmed && !p.readerFailed:
+		return "blinking cyan"
+	case p.state == Locked && p.blinks > 0 && !p.tigerArmed && !p.readerFailed:
+		return "blinking red"
+	case p.shownLed != nil:
+		return p.shownLed.String()
+	}
+	return off.String()
+}
+
+type color int
+
+const (
+	off color = iota
+	red
+	green
+	blue
+	yellow
+	cyan
+	purple
+)
+
+func (c color) String() string {
+	switch c {
+	case red:
+		return "red"
+	case green:
+		return "green"
+	case blue:
+		return "blue"
+	case yellow:
+		return "yellow"
+	case cyan:
+		return "cyan"
+	case purple:
+		return "purple"
+	}
+	return "off"
+}
+
+func (c color) set(led ui.ColorLed) {
+	switch c {
+	case red:
+		led.Red()
+	case green:
+		led.Green()
+	case blue:
+		led.Blue()
+	case yellow:
+		led.Yellow()
+	case cyan:
+		led.Cyan()
+	case purple:
+		led.Purple()
+	default:
+		led.Off()
+	}
+}
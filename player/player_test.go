@@ -0,0 +1,942 @@
+package player
+
+import (
+	"errors"
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/nfc"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"github.com/callebjorkell/rpi-nfc-player/ui"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSpeaker struct {
+	// lock is held for what the loads touch, since they are run next to the player.
+	lock      sync.Mutex
+	calls     []string
+	loaded    string
+	volume    int
+	remaining time.Duration
+	// delay is how long a load takes, loading how many loads are going on, and overlapped is set if there ever was
+	// more than one.
+	delay      time.Duration
+	loading    int
+	overlapped bool
+}
+
+func (s *fakeSpeaker) call(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.calls = append(s.calls, fmt.Sprintf(format, args...))
+}
+
+func (s *fakeSpeaker) Name() string { return "fake" }
+func (s *fakeSpeaker) SetPlaylist(c sonos.CardInfo) {
+	s.lock.Lock()
+	s.loading++
+	s.overlapped = s.overlapped || s.loading > 1
+	s.lock.Unlock()
+	time.Sleep(s.delay)
+
+	s.lock.Lock()
+	s.loading--
+	s.loaded = c.ID
+	s.lock.Unlock()
+	s.call("SetPlaylist %v", c.ID)
+}
+func (s *fakeSpeaker) HasLoaded(c sonos.CardInfo) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.loaded == c.ID
+}
+func (s *fakeSpeaker) Resume(c sonos.CardInfo) { s.call("Resume %v", c.ID) }
+func (s *fakeSpeaker) PlayStream(st radio.Station) {
+	s.lock.Lock()
+	s.loaded = ""
+	s.lock.Unlock()
+	s.call("PlayStream %v", st.Name)
+}
+func (s *fakeSpeaker) Play()                   { s.call("Play") }
+func (s *fakeSpeaker) Pause()                  { s.call("Pause") }
+func (s *fakeSpeaker) Stop()                   { s.call("Stop") }
+func (s *fakeSpeaker) Next()                   { s.call("Next") }
+func (s *fakeSpeaker) Previous()               { s.call("Previous") }
+func (s *fakeSpeaker) SetShuffle(shuffle bool) { s.call("SetShuffle %v", shuffle) }
+func (s *fakeSpeaker) Switch(name string) error {
+	s.call("Switch %v", name)
+	return nil
+}
+func (s *fakeSpeaker) MediaInfo() (sonos.State, error) {
+	return sonos.State{Track: "3", RelTime: "0:01:02"}, nil
+}
+func (s *fakeSpeaker) TransportState() (string, error) { return TransportPlaying, nil }
+func (s *fakeSpeaker) ConfigureSleepTimer(d time.Duration) error {
+	s.remaining = d
+	s.call("ConfigureSleepTimer %v", d)
+	return nil
+}
+func (s *fakeSpeaker) RemainingSleepTimer() (time.Duration, error) { return s.remaining, nil }
+func (s *fakeSpeaker) Volume() (int, error)                        { return s.volume, nil }
+func (s *fakeSpeaker) SetVolume(v int) error {
+	s.volume = v
+	s.call("SetVolume %v", v)
+	return nil
+}
+
+type fakeStore struct {
+	cards    map[string]sonos.CardInfo
+	active   string
+	usage    time.Duration
+	override time.Time
+	plays    []Play
+	pending  []PendingCard
+	// broken is set when the cards can't be read at all.
+	broken bool
+}
+
+func (s *fakeStore) ReadCard(id string) (sonos.CardInfo, error) {
+	if s.broken {
+		return sonos.CardInfo{}, errors.New("the database is closed")
+	}
+	c, ok := s.cards[id]
+	if !ok {
+		return c, fmt.Errorf("card %v: %w", id, ErrUnknownCard)
+	}
+	return c, nil
+}
+func (s *fakeStore) StoreCard(c *sonos.CardInfo) error {
+	s.cards[c.ID] = *c
+	return nil
+}
+func (s *fakeStore) ReadAll() (*[]sonos.CardInfo, error) {
+	var all []sonos.CardInfo
+	for _, c := range s.cards {
+		all = append(all, c)
+	}
+	return &all, nil
+}
+func (s *fakeStore) StoreActive(id string) error {
+	s.active = id
+	return nil
+}
+func (s *fakeStore) AddUsage(_ string, d time.Duration) (time.Duration, error) {
+	s.usage += d
+	return s.usage, nil
+}
+func (s *fakeStore) ReadUsage(string) (time.Duration, error) { return s.usage, nil }
+func (s *fakeStore) ReadOverride() (time.Time, error)        { return s.override, nil }
+func (s *fakeStore) StorePending(cards []PendingCard) error {
+	s.pending = cards
+	return nil
+}
+func (s *fakeStore) ReadPending() ([]PendingCard, error) { return s.pending, nil }
+func (s *fakeStore) AddPlay(p Play) error {
+	s.plays = append(s.plays, p)
+	return nil
+}
+
+type fakeLed struct {
+	color string
+}
+
+func (l *fakeLed) Purple() { l.color = "purple" }
+func (l *fakeLed) Yellow() { l.color = "yellow" }
+func (l *fakeLed) Cyan()   { l.color = "cyan" }
+func (l *fakeLed) Red()    { l.color = "red" }
+func (l *fakeLed) Green()  { l.color = "green" }
+func (l *fakeLed) Blue()   { l.color = "blue" }
+func (l *fakeLed) Off()    { l.color = "off" }
+
+type fakeTiger struct {
+	on bool
+}
+
+func (t *fakeTiger) On()  { t.on = true }
+func (t *fakeTiger) Off() { t.on = false }
+
+// the steps that a test can take, besides sending events to the player.
+type (
+	// fire runs all the timers that the player has started.
+	fire struct{}
+	// advance moves the clock forward, and lets the player tick.
+	advance time.Duration
+)
+
+type harness struct {
+	p       *Player
+	speaker *fakeSpeaker
+	store   *fakeStore
+	led     *fakeLed
+	tiger   *fakeTiger
+	now     time.Time
+	pending []interface{}
+}
+
+func newHarness(cfg Config) *harness {
+	h := &harness{
+		speaker: &fakeSpeaker{volume: 50},
+		store: &fakeStore{cards: map[string]sonos.CardInfo{
+			"album":  {ID: "album", Title: "Album"},
+			"other":  {ID: "other", Title: "Other"},
+			"radio1": {ID: "radio1", Stream: &radio.Station{Name: "One", URL: "http://one"}},
+			"radio2": {ID: "radio2", Stream: &radio.Station{Name: "Two", URL: "http://two"}},
+			"louder": {ID: "louder", Action: &sonos.Action{Type: sonos.VolumeAction, Value: "+5"}},
+			"bed":    {ID: "bed", Title: "Bed", SleepTimer: "10s"},
+		}},
+		led:   &fakeLed{},
+		tiger: &fakeTiger{},
+		now:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	h.p = New(h.speaker, h.store, h.led, h.tiger, cfg)
+	h.p.now = func() time.Time {
+		return h.now
+	}
+	h.p.after = func(_ time.Duration, e interface{}) {
+		h.pending = append(h.pending, e)
+	}
+	h.p.spawn = func(f func()) {
+		f()
+	}
+	h.p.show()
+	return h
+}
+
+func (h *harness) step(s interface{}) {
+	switch s := s.(type) {
+	case fire:
+		for i := 0; len(h.pending) > 0 && i < 100; i++ {
+			e := h.pending[0]
+			h.pending = h.pending[1:]
+			h.p.handle(e)
+		}
+	case advance:
+		h.now = h.now.Add(time.Duration(s))
+		h.p.handle(tick{})
+	default:
+		h.p.handle(s)
+	}
+}
+
+func put(id string) nfc.CardEvent {
+	return nfc.CardEvent{CardID: id, State: nfc.Activated}
+}
+
+func remove(id string) nfc.CardEvent {
+	return nfc.CardEvent{CardID: id, State: nfc.Deactivated}
+}
+
+func press(b ui.Button) ui.ButtonEvent {
+	return ui.ButtonEvent{Button: b, Pressed: true}
+}
+
+func speakerState(state string) SpeakerEvent {
+	return SpeakerEvent{State: state}
+}
+
+func TestTransitions(t *testing.T) {
+	quiet, _ := ParseRules("11:00-13:00", 0)
+	limited, _ := ParseRules("", time.Minute)
+	one := radio.Station{Name: "One", URL: "http://one"}
+	two := radio.Station{Name: "Two", URL: "http://two"}
+	three := radio.Station{Name: "Three", URL: "https://three"}
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		steps []interface{}
+		state State
+		led   string
+		tiger bool
+		// calls are the calls that are expected on the speaker, or nil if they don't matter.
+		calls []string
+	}{
+		{
+			name:  "starts idle",
+			state: Idle,
+			led:   "off",
+			calls: []string{},
+		},
+		{
+			name:  "card is loading",
+			steps: []interface{}{put("album")},
+			state: Loading,
+			led:   "purple",
+			calls: []string{"SetPlaylist album"},
+		},
+		{
+			name:  "card plays once loaded",
+			steps: []interface{}{put("album"), fire{}},
+			state: Playing,
+			led:   "green",
+			calls: []string{"SetPlaylist album", "Play"},
+		},
+		{
+			name:  "removing the card pauses",
+			steps: []interface{}{put("album"), fire{}, remove("album")},
+			state: Idle,
+			led:   "off",
+			calls: []string{"SetPlaylist album", "Play", "Pause"},
+		},
+		{
+			name:  "loaded queue is resumed",
+			steps: []interface{}{put("album"), fire{}, remove("album"), put("album"), fire{}},
+			state: Playing,
+			led:   "green",
+			calls: []string{"SetPlaylist album", "Play", "Pause", "Resume album"},
+		},
+		{
+			name:  "removal before the resume drops it",
+			steps: []interface{}{put("album"), fire{}, remove("album"), put("album"), remove("album"), fire{}},
+			state: Idle,
+			led:   "off",
+			calls: []string{"SetPlaylist album", "Play", "Pause", "Pause"},
+		},
+		{
+			name:  "removal while loading drops the load",
+			steps: []interface{}{put("album"), remove("album"), fire{}},
+			state: Idle,
+			led:   "off",
+			calls: []string{"SetPlaylist album", "Pause"},
+		},
+		{
+			name:  "unknown card is an error",
+			steps: []interface{}{put("nope")},
+			state: Error,
+			led:   "cyan",
+			calls: []string{},
+		},
+		{
+			name:  "unknown card blinks",
+			steps: []interface{}{put("nope"), fire{}},
+			state: Error,
+			led:   "cyan",
+			calls: []string{},
+		},
+		{
+			name:  "error clears when the card is removed",
+			steps: []interface{}{put("nope"), remove("nope")},
+			state: Idle,
+			led:   "off",
+			calls: []string{},
+		},
+		{
+			name:  "quiet hours lock the player",
+			cfg:   Config{Rules: quiet},
+			steps: []interface{}{put("album")},
+			state: Locked,
+			led:   "red",
+			calls: []string{},
+		},
+		{
+			name:  "locked player stops blinking",
+			cfg:   Config{Rules: quiet},
+			steps: []interface{}{put("album"), fire{}},
+			state: Locked,
+			led:   "off",
+			calls: []string{},
+		},
+		{
+			name:  "daily limit stops the music",
+			cfg:   Config{Rules: limited},
+			steps: []interface{}{put("album"), fire{}, advance(time.Minute)},
+			state: Locked,
+			led:   "red",
+			calls: []string{"SetPlaylist album", "Play", "Pause"},
+		},
+		{
+			name:  "card may come back within the grace period",
+			cfg:   Config{Grace: 5 * time.Second},
+			steps: []interface{}{put("album"), fire{}, remove("album"), put("album")},
+			state: Playing,
+			led:   "green",
+			calls: []string{"SetPlaylist album", "Play"},
+		},
+		{
+			name:  "grace period runs out",
+			cfg:   Config{Grace: 5 * time.Second},
+			steps: []interface{}{put("album"), fire{}, remove("album"), fire{}},
+			state: Idle,
+			led:   "off",
+			calls: []string{"SetPlaylist album", "Play", "Pause"},
+		},
+		{
+			name:  "other card during the grace period",
+			cfg:   Config{Grace: 5 * time.Second},
+			steps: []interface{}{put("album"), fire{}, remove("album"), put("other"), fire{}},
+			state: Playing,
+			led:   "green",
+			calls: []string{"SetPlaylist album", "Play", "Pause", "SetPlaylist other", "Play"},
+		},
+		{
+			name:  "blue button skips ahead",
+			steps: []interface{}{put("album"), fire{}, press(ui.Blue)},
+			state: Playing,
+			led:   "cyan",
+			calls: []string{"SetPlaylist album", "Play", "Next"},
+		},
+		{
+			name:  "button flash ends",
+			steps: []interface{}{put("album"), fire{}, press(ui.Red), fire{}},
+			state: Playing,
+			led:   "green",
+			calls: []string{"SetPlaylist album", "Play", "Previous"},
+		},
+		{
+			name:  "buttons do nothing when idle",
+			steps: []interface{}{press(ui.Blue), press(ui.Red)},
+			state: Idle,
+			led:   "off",
+			calls: []string{},
+		},
+		{
+			name:  "blue button switches radio station",
+			steps: []interface{}{put("radio1"), fire{}, press(ui.Blue)},
+			state: Playing,
+			led:   "cyan",
+			calls: []string{"SetPlaylist radio1", "Play", "PlayStream Two", "Play"},
+		},
+		{
+			name:  "configured stations are cycled in order",
+			cfg:   Config{Stations: []radio.Station{three, one, two}},
+			steps: []interface{}{put("radio1"), fire{}, press(ui.Blue), press(ui.Blue)},
+			state: Playing,
+			led:   "cyan",
+			calls: []string{"SetPlaylist radio1", "Play", "PlayStream Two", "Play", "PlayStream Three", "Play"},
+		},
+		{
+			name:  "station that isn't configured moves to the first one",
+			cfg:   Config{Stations: []radio.Station{three, two}},
+			steps: []interface{}{put("radio1"), fire{}, press(ui.Blue)},
+			state: Playing,
+			led:   "cyan",
+			calls: []string{"SetPlaylist radio1", "Play", "PlayStream Three", "Play"},
+		},
+		{
+			name:  "station that isn't configured moves back to the last one",
+			cfg:   Config{Stations: []radio.Station{three, two}},
+			steps: []interface{}{put("radio1"), fire{}, press(ui.Red)},
+			state: Playing,
+			led:   "yellow",
+			calls: []string{"SetPlaylist radio1", "Play", "PlayStream Two", "Play"},
+		},
+		{
+			name:  "tiger is let loose when idle",
+			steps: []interface{}{press(ui.TigerSwitch)},
+			state: Idle,
+			led:   "red",
+			tiger: true,
+		},
+		{
+			name:  "tiger sleeps while playing",
+			steps: []interface{}{press(ui.TigerSwitch), put("album"), fire{}},
+			state: Playing,
+			led:   "green",
+			tiger: false,
+		},
+		{
+			name:  "tiger wakes when the card is removed",
+			steps: []interface{}{press(ui.TigerSwitch), put("album"), fire{}, remove("album")},
+			state: Idle,
+			led:   "red",
+			tiger: true,
+		},
+		{
+			name:  "tiger can be disarmed",
+			steps: []interface{}{press(ui.TigerSwitch), ui.ButtonEvent{Button: ui.TigerSwitch}},
+			state: Idle,
+			led:   "off",
+		},
+		{
+			name:  "speaker paused from elsewhere",
+			steps: []interface{}{put("album"), fire{}, advance(5 * time.Second), speakerState(TransportPaused)},
+			state: Paused,
+			led:   "blue",
+			calls: []string{"SetPlaylist album", "Play"},
+		},
+		{
+			name:  "stopped speaker is ignored right after starting",
+			steps: []interface{}{put("album"), fire{}, speakerState(TransportStopped)},
+			state: Playing,
+			led:   "green",
+		},
+		{
+			name: "speaker started from elsewhere",
+			steps: []interface{}{put("album"), fire{}, advance(5 * time.Second), speakerState(TransportPaused),
+				speakerState(TransportPlaying)},
+			state: Playing,
+			led:   "green",
+		},
+		{
+			name:  "unreachable speaker is an error",
+			steps: []interface{}{put("album"), fire{}, SpeakerEvent{Err: errors.New("gone")}},
+			state: Error,
+			led:   "yellow",
+		},
+		{
+			name: "speaker comes back",
+			steps: []interface{}{put("album"), fire{}, SpeakerEvent{Err: errors.New("gone")},
+				speakerState(TransportPlaying)},
+			state: Playing,
+			led:   "green",
+		},
+		{
+			name:  "control card runs its action",
+			steps: []interface{}{put("louder")},
+			state: Idle,
+			led:   "blue",
+			calls: []string{"SetVolume 55"},
+		},
+		{
+			name:  "control card removal leaves the speaker alone",
+			steps: []interface{}{put("louder"), fire{}, remove("louder")},
+			state: Idle,
+			led:   "off",
+			calls: []string{"SetVolume 55"},
+		},
+		{
+			name:  "sleep timer of the card is set",
+			cfg:   Config{SleepFade: 30 * time.Second},
+			steps: []interface{}{put("bed"), fire{}},
+			state: Playing,
+			led:   "green",
+			calls: []string{"SetPlaylist bed", "Play", "ConfigureSleepTimer 10s"},
+		},
+		{
+			name:  "sleep timer fades out the music",
+			cfg:   Config{SleepFade: 30 * time.Second},
+			steps: []interface{}{put("bed"), fire{}, advance(5 * time.Second), fire{}},
+			state: Paused,
+			led:   "blue",
+			calls: []string{"SetPlaylist bed", "Play", "ConfigureSleepTimer 10s", "ConfigureSleepTimer 0s",
+				"SetVolume 45", "SetVolume 40", "SetVolume 35", "SetVolume 30", "SetVolume 25", "SetVolume 20",
+				"SetVolume 15", "SetVolume 10", "SetVolume 5", "SetVolume 0", "Pause", "SetVolume 50"},
+		},
+		{
+			name:  "removal during the fade restores the volume",
+			cfg:   Config{SleepFade: 30 * time.Second},
+			steps: []interface{}{put("bed"), fire{}, advance(5 * time.Second), remove("bed"), fire{}},
+			state: Idle,
+			led:   "off",
+			calls: []string{"SetPlaylist bed", "Play", "ConfigureSleepTimer 10s", "ConfigureSleepTimer 0s", "Pause",
+				"SetVolume 50"},
+		},
+		{
+			name:  "bedtime applies a sleep timer",
+			cfg:   Config{Bedtime: 11 * time.Hour, BedtimeSleep: 20 * time.Minute},
+			steps: []interface{}{put("album"), fire{}},
+			state: Playing,
+			led:   "green",
+			calls: []string{"SetPlaylist album", "Play", "ConfigureSleepTimer 20m0s"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.cfg.Bedtime == 0 {
+				test.cfg.Bedtime = -1
+			}
+			h := newHarness(test.cfg)
+			for _, s := range test.steps {
+				h.step(s)
+			}
+
+			if h.p.state != test.state {
+				t.Errorf("expected state %v, got %v", test.state, h.p.state)
+			}
+			if h.led.color != test.led {
+				t.Errorf("expected LED %v, got %v", test.led, h.led.color)
+			}
+			if h.tiger.on != test.tiger {
+				t.Errorf("expected tiger to be %v, got %v", test.tiger, h.tiger.on)
+			}
+			if test.calls != nil {
+				calls := h.speaker.calls
+				if calls == nil {
+					calls = []string{}
+				}
+				if !reflect.DeepEqual(calls, test.calls) {
+					t.Errorf("expected speaker calls %v, got %v", test.calls, calls)
+				}
+			}
+		})
+	}
+}
+
+func TestStateIsSavedOnRemoval(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	for _, s := range []interface{}{put("album"), fire{}, remove("album")} {
+		h.step(s)
+	}
+
+	c := h.store.cards["album"]
+	if c.State == nil || c.State.CurrentTrack != 3 || c.State.CurrentPosition != "0:01:02" {
+		t.Errorf("expected the position to be saved, got %v", c.State)
+	}
+	if h.store.active != "" {
+		t.Errorf("expected no active card, got %v", h.store.active)
+	}
+	if len(h.store.plays) != 1 || h.store.plays[0].CardID != "album" {
+		t.Errorf("expected the play to be in the history, got %v", h.store.plays)
+	}
+}
+
+func TestUsageIsCounted(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	for _, s := range []interface{}{put("album"), fire{}, advance(time.Minute), advance(30 * time.Second), remove("album")} {
+		h.step(s)
+	}
+
+	if h.store.usage != 90*time.Second {
+		t.Errorf("expected 1m30s of usage, got %v", h.store.usage)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 5, 1, hour, min, 0, 0, time.UTC)
+	}
+	overnight, _ := ParseRules("19:30-07:00", 0)
+	limited, _ := ParseRules("", time.Hour)
+
+	tests := []struct {
+		name     string
+		rules    Rules
+		now      time.Time
+		used     time.Duration
+		override time.Time
+		allowed  bool
+	}{
+		{"no rules", Rules{}, at(3, 0), 10 * time.Hour, time.Time{}, true},
+		{"before quiet hours", overnight, at(19, 29), 0, time.Time{}, true},
+		{"quiet in the evening", overnight, at(19, 30), 0, time.Time{}, false},
+		{"quiet in the morning", overnight, at(6, 59), 0, time.Time{}, false},
+		{"after quiet hours", overnight, at(7, 0), 0, time.Time{}, true},
+		{"under the limit", limited, at(12, 0), 59 * time.Minute, time.Time{}, true},
+		{"limit reached", limited, at(12, 0), time.Hour, time.Time{}, false},
+		{"override", limited, at(12, 0), time.Hour, at(13, 0), true},
+		{"override passed", limited, at(12, 0), time.Hour, at(11, 0), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ok, _ := test.rules.Allowed(test.now, test.used, test.override); ok != test.allowed {
+				t.Errorf("expected allowed to be %v, got %v", test.allowed, ok)
+			}
+		})
+	}
+}
+
+func TestOverride(t *testing.T) {
+	quiet, _ := ParseRules("11:00-13:00", 0)
+	h := newHarness(Config{Bedtime: -1, Rules: quiet})
+	h.store.override = h.now.Add(time.Hour)
+	h.step(put("album"))
+	if h.p.state != Loading {
+		t.Errorf("expected the card to play while the rules are lifted, got %v", h.p.state)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []interface{}
+		cmd   Command
+		err   error
+		state State
+		calls []string
+	}{
+		{"pause while playing", []interface{}{put("album"), fire{}}, CommandPause, nil, Paused,
+			[]string{"SetPlaylist album", "Play", "Pause"}},
+		{"pause when idle", nil, CommandPause, ErrNotPlaying, Idle, []string{}},
+		{"play while paused", []interface{}{put("album"), fire{}, advance(5 * time.Second),
+			speakerState(TransportPaused)}, CommandPlay, nil, Playing, []string{"SetPlaylist album", "Play", "Play"}},
+		{"play when idle", nil, CommandPlay, ErrNothingToPlay, Idle, []string{}},
+		{"next while playing", []interface{}{put("album"), fire{}}, CommandNext, nil, Playing,
+			[]string{"SetPlaylist album", "Play", "Next"}},
+		{"previous while loading", []interface{}{put("album")}, CommandPrevious, ErrNotPlaying, Loading,
+			[]string{"SetPlaylist album"}},
+		{"unknown command", nil, Command("dance"), ErrUnknownCommand, Idle, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(Config{Bedtime: -1})
+			for _, s := range test.steps {
+				h.step(s)
+			}
+			reply := make(chan error, 1)
+			h.step(command{cmd: test.cmd, reply: reply})
+
+			if err := <-reply; err != test.err {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+			if h.p.state != test.state {
+				t.Errorf("expected state %v, got %v", test.state, h.p.state)
+			}
+			calls := h.speaker.calls
+			if calls == nil {
+				calls = []string{}
+			}
+			if !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("expected speaker calls %v, got %v", test.calls, calls)
+			}
+		})
+	}
+}
+
+func TestVolume(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	for _, v := range []int{35, 120, -3} {
+		reply := make(chan error, 1)
+		h.step(command{cmd: commandVolume, value: v, reply: reply})
+		if err := <-reply; err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	}
+	expected := []string{"SetVolume 35", "SetVolume 100", "SetVolume 0"}
+	if !reflect.DeepEqual(h.speaker.calls, expected) {
+		t.Errorf("expected speaker calls %v, got %v", expected, h.speaker.calls)
+	}
+	if v := h.p.Status().Volume; v != 0 {
+		t.Errorf("expected the status to show volume 0, got %v", v)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	w := h.p.Watch()
+	h.step(put("album"))
+	h.step(fire{})
+
+	// only the latest status is kept.
+	s := <-w
+	if s.State != Playing || s.Led != "green" || s.Card == nil || s.Card.Title != "Album" {
+		t.Errorf("expected the album to be playing with a green LED, got %v %v %v", s.State, s.Led, s.Card)
+	}
+	select {
+	case s := <-w:
+		t.Errorf("expected no more statuses, got %v", s.State)
+	default:
+	}
+
+	h.step(speakerState(TransportPlaying))
+	select {
+	case s := <-w:
+		t.Errorf("expected no status when nothing changed, got %v", s.State)
+	default:
+	}
+}
+
+func TestEvents(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	events := h.p.Events()
+	steps := []interface{}{
+		put("album"), fire{}, press(ui.Blue), SpeakerEvent{Err: errors.New("gone")}, remove("album"),
+		ui.ButtonEvent{Button: ui.TigerSwitch, Pressed: true}, ui.ButtonEvent{Button: ui.TigerSwitch, Pressed: true},
+		put("unknown"),
+	}
+	for _, s := range steps {
+		h.step(s)
+	}
+
+	expected := []Event{
+		{Type: EventCardActivated, State: Loading, Card: "album", Title: "Album"},
+		{Type: EventButton, State: Playing, Button: "blue"},
+		{Type: EventSpeakerError, State: Error, Card: "album", Title: "Album", Error: "gone"},
+		{Type: EventCardRemoved, State: Idle, Card: "album", Title: "Album"},
+		{Type: EventTigerArmed, State: Idle},
+		{Type: EventUnknownCard, State: Error, Card: "unknown"},
+	}
+	for _, want := range expected {
+		select {
+		case e := <-events:
+			e.Time = time.Time{}
+			if e != want {
+				t.Errorf("expected event %+v, got %+v", want, e)
+			}
+		default:
+			t.Fatalf("expected event %v, got nothing", want.Type)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("expected no more events, got %+v", e)
+	default:
+	}
+}
+
+func TestReaderFailure(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	events := h.p.Events()
+	h.step(put("album"))
+	h.step(fire{})
+	h.step(nfc.ReaderStatus{Err: errors.New("no answer")})
+	h.step(nfc.ReaderStatus{Err: errors.New("no answer")})
+	if s := h.p.Status(); !s.ReaderFailed || s.Led != "yellow" || s.State != Playing {
+		t.Errorf("expected a failed reader to show yellow and keep playing, got %+v", s)
+	}
+
+	h.step(nfc.ReaderStatus{OK: true})
+	if s := h.p.Status(); s.ReaderFailed || s.Led != "green" {
+		t.Errorf("expected the LED to go back to the state once the reader works, got %+v", s)
+	}
+
+	var got []Event
+	for len(events) > 0 {
+		e := <-events
+		e.Time = time.Time{}
+		got = append(got, e)
+	}
+	expected := []Event{
+		{Type: EventCardActivated, State: Loading, Card: "album", Title: "Album"},
+		{Type: EventReaderError, State: Playing, Error: "no answer"},
+		{Type: EventReaderRecovered, State: Playing},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected events %+v, got %+v", expected, got)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	h.step(assign{card: &sonos.CardInfo{Title: "New"}, reply: make(chan error, 1)})
+	if s := h.p.Status(); s.Assigning == nil || s.Assigning.Title != "New" {
+		t.Fatalf("expected the status to show the assignment, got %v", s.Assigning)
+	}
+
+	h.step(put("blank"))
+	h.step(remove("blank"))
+	if c, ok := h.store.cards["blank"]; !ok || c.Title != "New" {
+		t.Errorf("expected the card to be assigned, got %v", c)
+	}
+	if h.p.state != Idle || len(h.speaker.calls) != 0 {
+		t.Errorf("expected the assigned card not to play, got %v and %v", h.p.state, h.speaker.calls)
+	}
+	if s := h.p.Status(); s.Assigning != nil || s.Assigned != "blank" {
+		t.Errorf("expected the assignment to be done, got %v", s)
+	}
+
+	h.step(put("blank"))
+	h.step(fire{})
+	if h.p.state != Playing {
+		t.Errorf("expected the card to play the next time, got %v", h.p.state)
+	}
+}
+
+func TestBrokenStore(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	h.store.broken = true
+	h.step(put("album"))
+
+	if h.p.state != Error || h.led.color != "yellow" {
+		t.Errorf("expected the error state with a yellow LED, got %v and %v", h.p.state, h.led.color)
+	}
+	if len(h.store.pending) != 0 || h.p.unknown {
+		t.Errorf("expected the card not to be taken for an unknown one, got the pending cards %v", h.store.pending)
+	}
+	h.step(remove("album"))
+	if h.p.state != Idle {
+		t.Errorf("expected the player to be idle once the card is removed, got %v", h.p.state)
+	}
+}
+
+func TestCardAdded(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	h.step(put("old"))
+	h.step(remove("old"))
+	h.step(put("new"))
+
+	if len(h.store.pending) != 2 || h.store.pending[0].ID != "new" || h.store.pending[1].ID != "old" {
+		t.Fatalf("expected both cards to be pending, newest first, got %v", h.store.pending)
+	}
+	h.step(blink{seq: h.p.seq})
+	if h.led.color != "off" {
+		t.Errorf("expected the LED to blink, got %v", h.led.color)
+	}
+
+	h.store.cards["new"] = sonos.CardInfo{ID: "new", Title: "Added"}
+	h.step(cardAdded{id: "new"})
+	if len(h.store.pending) != 1 || h.store.pending[0].ID != "old" {
+		t.Errorf("expected the card to be pending no more, got %v", h.store.pending)
+	}
+	if h.p.state != Loading {
+		t.Errorf("expected the card on the lid to start playing, got %v", h.p.state)
+	}
+}
+
+func TestCardDeleted(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	h.step(put("album"))
+	h.step(fire{})
+	h.step(cardDeleted{id: "other"})
+	if h.p.state != Playing || h.store.active != "album" {
+		t.Fatalf("expected another card to leave the player alone, got %v with %q active", h.p.state, h.store.active)
+	}
+
+	delete(h.store.cards, "album")
+	h.step(cardDeleted{id: "album"})
+	if h.p.state != Idle || h.p.card != nil || h.store.active != "" || h.p.lastPlayed != "" {
+		t.Errorf("expected the deleted card to be stopped and forgotten, got %v with %q active", h.p.state,
+			h.store.active)
+	}
+	h.step(remove("album"))
+	if h.p.state != Idle {
+		t.Errorf("expected the player to stay idle when the card is taken off, got %v", h.p.state)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	h := newHarness(Config{Bedtime: -1})
+	reply := make(chan string, 1)
+	h.step(capture{reply: reply})
+	h.step(put("album"))
+
+	if id := <-reply; id != "album" {
+		t.Errorf("expected the card to be read, got %v", id)
+	}
+	h.step(remove("album"))
+	if h.p.state != Idle || len(h.speaker.calls) != 0 {
+		t.Errorf("expected the card that was read not to play, got %v and %v", h.p.state, h.speaker.calls)
+	}
+}
+
+// TestLoadsTakeTurns taps two cards right after each other while the loads are run in the background, like they are
+// for real, and checks that the speaker only ever loads one card at a time and ends up playing the last one.
+func TestLoadsTakeTurns(t *testing.T) {
+	h := newHarness(Config{})
+	h.speaker.delay = 50 * time.Millisecond
+	events := make(chan interface{}, 10)
+	h.p.after = func(_ time.Duration, e interface{}) {
+		events <- e
+	}
+	h.p.spawn = func(f func()) {
+		go f()
+	}
+
+	h.step(put("album"))
+	h.step(put("other"))
+	timeout := time.After(5 * time.Second)
+	for h.p.state != Playing {
+		select {
+		case e := <-events:
+			h.step(e)
+		case <-timeout:
+			t.Fatalf("expected the player to start playing, it is %v", h.p.state)
+		}
+	}
+
+	h.speaker.lock.Lock()
+	defer h.speaker.lock.Unlock()
+	if h.speaker.overlapped {
+		t.Error("expected the loads to take turns")
+	}
+	if h.p.card.ID != "other" || h.speaker.loaded != "other" {
+		t.Errorf("expected the last card to be loaded, got %v on the lid and %v loaded", h.p.card.ID, h.speaker.loaded)
+	}
+	if last := h.speaker.calls[len(h.speaker.calls)-1]; last != "Play" {
+		t.Errorf("expected the last card to be played, the last call was %v", last)
+	}
+}
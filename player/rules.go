@@ -0,0 +1,123 @@
+package player
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rulesCheckInterval is how often the usage is counted, and the rules checked, while playing.
+const rulesCheckInterval = time.Minute
+
+// Rules decide when the player is allowed to play, and for how long every day.
+type Rules struct {
+	// QuietFrom and QuietTo are the start and end of the quiet hours, as time since midnight. They are equal when
+	// there are no quiet hours.
+	QuietFrom time.Duration `json:"quietFrom"`
+	QuietTo   time.Duration `json:"quietTo"`
+	// DailyLimit is how long the player may play each day, or 0 if there is no limit.
+	DailyLimit time.Duration `json:"dailyLimit"`
+}
+
+// ParseRules creates the rules from quiet hours given as HH:MM-HH:MM (or nothing) and a daily limit.
+func ParseRules(quiet string, limit time.Duration) (Rules, error) {
+	r := Rules{DailyLimit: limit}
+	if quiet == "" {
+		return r, nil
+	}
+
+	parts := strings.Split(quiet, "-")
+	if len(parts) != 2 {
+		return r, fmt.Errorf("invalid quiet hours %v, expected HH:MM-HH:MM", quiet)
+	}
+	from, err := ParseTimeOfDay(parts[0])
+	if err != nil {
+		return r, err
+	}
+	to, err := ParseTimeOfDay(parts[1])
+	if err != nil {
+		return r, err
+	}
+	r.QuietFrom = from
+	r.QuietTo = to
+	return r, nil
+}
+
+// ParseTimeOfDay reads a HH:MM time into the time since midnight.
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %v, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (r Rules) String() string {
+	quiet := "none"
+	if r.QuietFrom != r.QuietTo {
+		quiet = fmt.Sprintf("%v-%v", formatTimeOfDay(r.QuietFrom), formatTimeOfDay(r.QuietTo))
+	}
+	limit := "none"
+	if r.DailyLimit > 0 {
+		limit = r.DailyLimit.String()
+	}
+	return fmt.Sprintf("quiet hours: %v, daily limit: %v", quiet, limit)
+}
+
+// Allowed checks if playing is allowed at the given time, with the given amount of playing already done that day. If
+// not, the reason is returned as well. Everything is allowed until the override has passed.
+func (r Rules) Allowed(now time.Time, used time.Duration, override time.Time) (bool, string) {
+	if now.Before(override) {
+		return true, ""
+	}
+
+	if r.QuietFrom != r.QuietTo {
+		t := sinceMidnight(now)
+		quiet := t >= r.QuietFrom && t < r.QuietTo
+		if r.QuietFrom > r.QuietTo {
+			// the quiet hours span midnight
+			quiet = t >= r.QuietFrom || t < r.QuietTo
+		}
+		if quiet {
+			return false, "quiet hours"
+		}
+	}
+
+	if r.DailyLimit > 0 && used >= r.DailyLimit {
+		return false, "daily limit reached"
+	}
+	return true, ""
+}
+
+// override is the time until which the rules are lifted, or the zero time if they aren't.
+func (p *Player) override() time.Time {
+	until, err := p.store.ReadOverride()
+	if err != nil {
+		log.Warn("Could not read the rules override: ", err)
+	}
+	return until
+}
+
+// Day formats the date that usage is recorded under.
+func Day(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// countUsage adds the time played since it was last counted to today's usage, and returns the usage.
+func (p *Player) countUsage() time.Duration {
+	now := p.now()
+	used, err := p.store.AddUsage(Day(now), now.Sub(p.counted))
+	if err != nil {
+		log.Warn("Could not store today's usage: ", err)
+	}
+	p.counted = now
+	return used
+}
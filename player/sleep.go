@@ -0,0 +1,149 @@
+package player
+
+import (
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"time"
+)
+
+// fade is a fade out of the volume that is in progress, one step every second.
+type fade struct {
+	volume int
+	step   int
+	steps  int
+}
+
+func (p *Player) pastBedtime(now time.Time) bool {
+	if p.cfg.Bedtime < 0 {
+		return false
+	}
+	return sinceMidnight(now) >= p.cfg.Bedtime
+}
+
+// applySleepTimer starts the sleep timer of the card when it starts playing. After bedtime, the bedtime timer is
+// used for cards that don't have one of their own, unless a timer is running already.
+func (p *Player) applySleepTimer(card sonos.CardInfo) {
+	d := card.SleepDuration()
+	now := p.now()
+	if d == 0 && p.pastBedtime(now) {
+		if remaining, err := p.speaker.RemainingSleepTimer(); err != nil || remaining > 0 {
+			return
+		}
+		d = p.cfg.BedtimeSleep
+		p.lastBedtime = Day(now)
+	}
+	if d == 0 {
+		return
+	}
+
+	log.Infof("Stopping playback in %v", d)
+	if err := p.speaker.ConfigureSleepTimer(d); err != nil {
+		log.Warn("Could not set the sleep timer: ", err)
+	}
+}
+
+// tick keeps an eye on the sleep timer of the speaker and on the listening rules while playing. The music is faded
+// out before the sleep timer runs out, and the bedtime sleep timer is applied if something is still playing at
+// bedtime.
+func (p *Player) tick() {
+	if p.state != Playing || p.fade != nil {
+		return
+	}
+
+	now := p.now()
+	if now.Sub(p.counted) >= rulesCheckInterval {
+		used := p.countUsage()
+		if ok, reason := p.cfg.Rules.Allowed(now, used, p.override()); !ok {
+			log.Infof("Stopping playback: %v", reason)
+			p.speaker.Pause()
+			p.saveActive()
+			p.lock()
+			return
+		}
+	}
+
+	if p.pastBedtime(now) && p.lastBedtime != Day(now) {
+		p.lastBedtime = Day(now)
+		if remaining, err := p.speaker.RemainingSleepTimer(); err == nil && remaining == 0 {
+			log.Infof("Bedtime! Stopping playback in %v", p.cfg.BedtimeSleep)
+			if err := p.speaker.ConfigureSleepTimer(p.cfg.BedtimeSleep); err != nil {
+				log.Warn("Could not set the sleep timer: ", err)
+			}
+		}
+	}
+
+	remaining, err := p.speaker.RemainingSleepTimer()
+	if err != nil {
+		log.Debug("Could not read the sleep timer: ", err)
+	} else if remaining > 0 && remaining <= p.cfg.SleepFade+tickInterval {
+		p.startFade(remaining)
+	}
+}
+
+// startFade lowers the volume to nothing over the given duration, before pausing and saving the state of the card.
+// The sleep timer is cancelled so that the speaker doesn't stop in the middle of the fade, and the volume is restored
+// afterwards so that the next card plays like normal.
+func (p *Player) startFade(d time.Duration) {
+	log.Infof("Sleep timer running out, fading out over %v", d)
+	if err := p.speaker.ConfigureSleepTimer(0); err != nil {
+		log.Warn("Could not cancel the sleep timer: ", err)
+	}
+	volume, err := p.speaker.Volume()
+	if err != nil {
+		log.Warn("Could not read the volume: ", err)
+		p.finishFade()
+		return
+	}
+
+	p.fade = &fade{volume: volume, steps: int(d / time.Second)}
+	if p.fade.steps == 0 {
+		p.finishFade()
+		return
+	}
+	p.after(time.Second, fadeStep{seq: p.seq})
+}
+
+func (p *Player) fadeStep(e fadeStep) {
+	if p.fade == nil || e.seq != p.seq {
+		return
+	}
+
+	f := p.fade
+	f.step++
+	if err := p.speaker.SetVolume(f.volume * (f.steps - f.step) / f.steps); err != nil {
+		log.Warn("Could not lower the volume: ", err)
+		p.finishFade()
+		return
+	}
+	if f.step >= f.steps {
+		p.finishFade()
+		return
+	}
+	p.after(time.Second, fadeStep{seq: p.seq})
+}
+
+func (p *Player) finishFade() {
+	p.speaker.Pause()
+	p.saveActive()
+	p.endFade()
+	p.setState(Paused)
+}
+
+// endFade restores the volume if the music was being faded out.
+func (p *Player) endFade() {
+	if p.fade == nil {
+		return
+	}
+	if p.fade.volume > 0 {
+		if err := p.speaker.SetVolume(p.fade.volume); err != nil {
+			log.Warn("Could not restore the volume: ", err)
+		}
+	}
+	p.fade = nil
+}
+
+// saveActive saves the state of the card on the lid, unless it's a radio stream.
+func (p *Player) saveActive() {
+	if p.card != nil && p.stream == nil {
+		p.saveState(p.card.ID)
+	}
+}
@@ -0,0 +1,91 @@
+package player
+
+import (
+	"errors"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	"time"
+)
+
+// Speaker is the part of the Sonos speaker that the player controls.
+type Speaker interface {
+	Name() string
+	SetPlaylist(playlist sonos.CardInfo)
+	HasLoaded(playlist sonos.CardInfo) bool
+	Resume(playlist sonos.CardInfo)
+	PlayStream(station radio.Station)
+	Play()
+	Pause()
+	Stop()
+	Next()
+	Previous()
+	SetShuffle(shuffle bool)
+	Switch(name string) error
+	MediaInfo() (sonos.State, error)
+	TransportState() (string, error)
+	ConfigureSleepTimer(d time.Duration) error
+	RemainingSleepTimer() (time.Duration, error)
+	Volume() (int, error)
+	SetVolume(volume int) error
+}
+
+// Store is where the cards, and the usage of the player, are kept.
+type Store interface {
+	ReadCard(id string) (sonos.CardInfo, error)
+	StoreCard(c *sonos.CardInfo) error
+	ReadAll() (*[]sonos.CardInfo, error)
+	StoreActive(id string) error
+	AddUsage(day string, d time.Duration) (time.Duration, error)
+	ReadUsage(day string) (time.Duration, error)
+	ReadOverride() (time.Time, error)
+	AddPlay(p Play) error
+	StorePending(cards []PendingCard) error
+	ReadPending() ([]PendingCard, error)
+}
+
+// ErrUnknownCard is what ReadCard of a Store wraps when the card has not been added.
+var ErrUnknownCard = errors.New("the card has not been added")
+
+// Play is a card that was played, kept in the play history.
+type Play struct {
+	CardID string    `json:"cardId"`
+	Title  string    `json:"title"`
+	Time   time.Time `json:"time"`
+}
+
+// Transport states reported by the speaker.
+const (
+	TransportPlaying = "PLAYING"
+	TransportPaused  = "PAUSED_PLAYBACK"
+	TransportStopped = "STOPPED"
+)
+
+// SpeakerEvent is sent when the transport state or the volume of the speaker changes, or when it can't be reached.
+type SpeakerEvent struct {
+	State  string
+	Volume int
+	Err    error
+}
+
+// WatchSpeaker polls the transport state and the volume of the speaker, and sends an event whenever they change.
+func WatchSpeaker(s Speaker, interval time.Duration) <-chan SpeakerEvent {
+	events := make(chan SpeakerEvent, 1)
+	go func() {
+		last := SpeakerEvent{}
+		for {
+			<-time.After(interval)
+
+			state, err := s.TransportState()
+			e := SpeakerEvent{State: state, Err: err}
+			if err == nil {
+				e.Volume, e.Err = s.Volume()
+			}
+			if e.State == last.State && e.Volume == last.Volume && (e.Err == nil) == (last.Err == nil) {
+				continue
+			}
+			last = e
+			events <- e
+		}
+	}()
+	return events
+}
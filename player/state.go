@@ -0,0 +1,43 @@
+package player
+
+// State is the state that the player is in. Only the event loop of the player changes it.
+type State int
+
+const (
+	// Idle means that there is no card on the lid, and nothing is playing.
+	Idle State = iota
+	// Loading means that a card was put on the lid, and its queue is being loaded onto the speaker.
+	Loading
+	// Playing means that the music of the card on the lid is playing.
+	Playing
+	// Paused means that there is a card on the lid, but the speaker has stopped playing it. This happens when the
+	// sleep timer has run out, or when the music was paused from somewhere else.
+	Paused
+	// Error means that the card on the lid could not be played, or that the speaker could not be reached.
+	Error
+	// Locked means that the listening rules did not allow the card on the lid to play.
+	Locked
+)
+
+func (s State) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Loading:
+		return "loading"
+	case Playing:
+		return "playing"
+	case Paused:
+		return "paused"
+	case Error:
+		return "error"
+	case Locked:
+		return "locked"
+	}
+	return "unknown"
+}
+
+// active tells if the speaker is, or is just about to be, playing something.
+func (s State) active() bool {
+	return s == Loading || s == Playing
+}
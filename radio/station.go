@@ -0,0 +1,109 @@
+package radio
+
+import (
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	log "github.com/sirupsen/logrus"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// client fetches the logos and checks the streams. It gives up after a while, so that a station that doesn't answer
+// doesn't hold up the labels and the web UI.
+var client = &http.Client{Timeout: 5 * time.Second}
+
+// Station is an internet radio station that is played from a stream URL, like an MP3 or AAC Icecast stream.
+type Station struct {
+	// Name is the human readable name of the station.
+	Name string `json:"name"`
+	// URL is the address of the stream.
+	URL string `json:"url"`
+	// Logo is a file path or URL to an image that is used instead of the cover art on labels.
+	Logo string `json:"logo,omitempty"`
+}
+
+func New(streamUrl, name, logo string) (*Station, error) {
+	u, err := url.Parse(streamUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported stream scheme %v", u.Scheme)
+	}
+	if name == "" {
+		name = u.Host
+	}
+	return &Station{Name: name, URL: streamUrl, Logo: logo}, nil
+}
+
+func (s Station) Title() string {
+	return s.Name
+}
+
+func (s Station) FullTitle() string {
+	return s.Title()
+}
+
+func (s Station) Artist() string {
+	return ""
+}
+
+func (s Station) String() string {
+	return fmt.Sprintf("Type: radio, name: %v, url: %v", s.Name, s.URL)
+}
+
+func (s Station) Id() string {
+	r := strings.NewReplacer("/", "_", ":", "_", " ", "_")
+	return fmt.Sprintf("r-%v", r.Replace(s.Name))
+}
+
+func (s Station) CoverArt() *image.Image {
+	if s.Logo == "" {
+		return deezer.DefaultCoverArt()
+	}
+
+	var r io.ReadCloser
+	if strings.HasPrefix(s.Logo, "http://") || strings.HasPrefix(s.Logo, "https://") {
+		res, err := client.Get(s.Logo)
+		if err != nil {
+			log.Debug(err)
+			return deezer.DefaultCoverArt()
+		}
+		r = res.Body
+	} else {
+		f, err := os.Open(s.Logo)
+		if err != nil {
+			log.Debug(err)
+			return deezer.DefaultCoverArt()
+		}
+		r = f
+	}
+	defer r.Close()
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		log.Debug(err)
+		return deezer.DefaultCoverArt()
+	}
+	return &img
+}
+
+// Check connects to the stream to make sure that it is available.
+func (s Station) Check() error {
+	res, err := client.Get(s.URL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("stream responded with %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+func showRules(override string) {
+	if override != "" {
+		d, err := time.ParseDuration(override)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		// the override is for the player that is running, which keeps it in its database.
+		if daemon == nil {
+			log.Error("No player is running, the rules can only be lifted while it is")
+			return
+		}
+		if err := daemon.SetOverride(time.Now().Add(d)); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	r, err := db.ReadRules()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	used, err := db.ReadUsage(player.Day(time.Now()))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	fmt.Printf("Rules:    %v\n", r)
+	fmt.Printf("Today:    %v played", used.Round(time.Second))
+	if r.DailyLimit > 0 {
+		left := r.DailyLimit - used
+		if left < 0 {
+			left = 0
+		}
+		fmt.Printf(", %v left", left.Round(time.Second))
+	}
+	fmt.Println()
+	until, err := db.ReadOverride()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if time.Now().Before(until) {
+		fmt.Printf("Override: rules lifted until %v\n", until.Format("2006-01-02 15:04"))
+	}
+	if ok, reason := r.Allowed(time.Now(), used, until); ok {
+		fmt.Println("Status:   playing is allowed")
+	} else {
+		fmt.Printf("Status:   playing is not allowed (%v)\n", reason)
+	}
+}
@@ -0,0 +1,133 @@
+package sonos
+
+import (
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ActionType string
+
+const (
+	// ShuffleAction turns shuffle on or off. The value is "on" or "off".
+	ShuffleAction ActionType = "shuffle"
+	// VolumeAction changes the volume. The value is a relative change like "-5" or "+5".
+	VolumeAction ActionType = "volume"
+	// ResetAction stops playing, and forgets the state of the card that was last played.
+	ResetAction ActionType = "reset"
+	// SleepAction starts a sleep timer. The value is a duration like "15m".
+	SleepAction ActionType = "sleep"
+	// SpeakerAction moves the player to another speaker. The value is the name of the speaker.
+	SpeakerAction ActionType = "speaker"
+)
+
+// Action is what a control card does instead of playing music.
+type Action struct {
+	Type  ActionType `json:"type"`
+	Value string     `json:"value,omitempty"`
+}
+
+// ParseAction reads an action from TYPE:VALUE, like "volume:-5" or "sleep:15m". Actions without a value are given
+// just by the type.
+func ParseAction(s string) (*Action, error) {
+	t, v, _ := strings.Cut(s, ":")
+	a := &Action{Type: ActionType(t), Value: v}
+
+	switch a.Type {
+	case ShuffleAction:
+		if v != "on" && v != "off" {
+			return nil, fmt.Errorf("shuffle needs to be on or off, got %v", v)
+		}
+	case VolumeAction:
+		if _, err := a.VolumeChange(); err != nil {
+			return nil, err
+		}
+	case ResetAction:
+		a.Value = ""
+	case SleepAction:
+		if _, err := a.SleepDuration(); err != nil {
+			return nil, err
+		}
+	case SpeakerAction:
+		if v == "" {
+			return nil, fmt.Errorf("speaker needs the name of a speaker")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %v", t)
+	}
+	return a, nil
+}
+
+func (a Action) VolumeChange() (int, error) {
+	change, err := strconv.Atoi(a.Value)
+	if err != nil {
+		return 0, fmt.Errorf("volume needs a change like -5 or +5, got %v", a.Value)
+	}
+	return change, nil
+}
+
+func (a Action) SleepDuration() (time.Duration, error) {
+	d, err := time.ParseDuration(a.Value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("sleep needs a duration like 15m, got %v", a.Value)
+	}
+	return d, nil
+}
+
+func (a Action) Title() string {
+	switch a.Type {
+	case ShuffleAction:
+		return "Shuffle " + a.Value
+	case VolumeAction:
+		if strings.HasPrefix(a.Value, "-") {
+			return "Volume down"
+		}
+		return "Volume up"
+	case ResetAction:
+		return "Stop and start over"
+	case SleepAction:
+		return "Sleep in " + a.Value
+	case SpeakerAction:
+		return "Play on " + a.Value
+	}
+	return string(a.Type)
+}
+
+func (a Action) FullTitle() string {
+	return a.Title()
+}
+
+func (a Action) Artist() string {
+	return ""
+}
+
+func (a Action) String() string {
+	return fmt.Sprintf("Type: action, action: %v, value: %v", a.Type, a.Value)
+}
+
+func (a Action) Id() string {
+	r := strings.NewReplacer(" ", "_", "/", "_", ":", "_")
+	return r.Replace(fmt.Sprintf("x-%v-%v", a.Type, a.Value))
+}
+
+func (a Action) CoverArt() *image.Image {
+	switch a.Type {
+	case ShuffleAction:
+		return actionIcon(iconShuffle)
+	case VolumeAction:
+		if strings.HasPrefix(a.Value, "-") {
+			return actionIcon(iconVolumeDown)
+		}
+		return actionIcon(iconVolumeUp)
+	case ResetAction:
+		return actionIcon(iconStop)
+	case SleepAction:
+		return actionIcon(iconSleep)
+	case SpeakerAction:
+		return actionIcon(iconSpeaker)
+	}
+	return deezer.DefaultCoverArt()
+}
@@ -0,0 +1,225 @@
+package sonos
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const browsePageSize = 100
+
+// Favorite is a Sonos favourite or a saved Sonos playlist. The URI and metadata are replayed as they are, so it works
+// for anything that the speaker knows how to play.
+type Favorite struct {
+	// ID is the object ID in the content directory, like FV:2/3 or SQ:5
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	URI      string `json:"uri"`
+	Metadata string `json:"metadata,omitempty"`
+}
+
+// Queueable tells if the favorite is something that should be added to the queue, rather than be played directly
+// like a radio station or a single stream.
+func (f Favorite) Queueable() bool {
+	for _, prefix := range []string{"x-rincon-cpcontainer:", "file:///jffs/settings/savedqueues.rsq", "x-rincon-playlist:"} {
+		if strings.HasPrefix(f.URI, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Favorite) String() string {
+	return fmt.Sprintf("Type: favorite, ID: %v, title: %v, uri: %v", f.ID, f.Title, f.URI)
+}
+
+// TrackInfo is the metadata of a track in the queue, or of what is currently playing.
+type TrackInfo struct {
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	AlbumArtURI string `json:"albumArtUri,omitempty"`
+	URI         string `json:"uri"`
+}
+
+func (t TrackInfo) String() string {
+	if t.Artist == "" {
+		return t.Title
+	}
+	return fmt.Sprintf("%v - %v", t.Artist, t.Title)
+}
+
+type didlObject struct {
+	ID          string `xml:"id,attr"`
+	ParentID    string `xml:"parentID,attr"`
+	Title       string `xml:"title"`
+	Creator     string `xml:"creator"`
+	Artist      string `xml:"artist"`
+	Album       string `xml:"album"`
+	AlbumArtURI string `xml:"albumArtURI"`
+	Class       string `xml:"class"`
+	Res         string `xml:"res"`
+	ResMD       string `xml:"resMD"`
+}
+
+type didlResult struct {
+	Items      []didlObject `xml:"item"`
+	Containers []didlObject `xml:"container"`
+}
+
+// ParseTrackMetadata reads the title, artist, album and album art from the DIDL metadata of a single track, like the
+// one found in TrackMetaData of the position info.
+func ParseTrackMetadata(didl string) (TrackInfo, error) {
+	var result didlResult
+	if err := xml.Unmarshal([]byte(didl), &result); err != nil {
+		return TrackInfo{}, fmt.Errorf("could not parse the track metadata: %v", err)
+	}
+	if len(result.Items) == 0 {
+		return TrackInfo{}, errors.New("no track found in the metadata")
+	}
+	return result.Items[0].trackInfo(), nil
+}
+
+func (o didlObject) trackInfo() TrackInfo {
+	artist := o.Creator
+	if artist == "" {
+		artist = o.Artist
+	}
+	return TrackInfo{
+		Title:       o.Title,
+		Artist:      artist,
+		Album:       o.Album,
+		AlbumArtURI: o.AlbumArtURI,
+		URI:         o.Res,
+	}
+}
+
+// NowPlaying fetches the metadata of the track that the speaker is currently playing.
+func (s *SonosSpeaker) NowPlaying() (TrackInfo, error) {
+	state, err := s.MediaInfo()
+	if err != nil {
+		return TrackInfo{}, err
+	}
+	if state.TrackMetaData == "" || state.TrackMetaData == "NOT_IMPLEMENTED" {
+		return TrackInfo{URI: state.TrackURI}, nil
+	}
+	t, err := ParseTrackMetadata(state.TrackMetaData)
+	if err != nil {
+		return TrackInfo{}, err
+	}
+	t.URI = state.TrackURI
+	t.AlbumArtURI = s.absoluteURI(t.AlbumArtURI)
+	return t, nil
+}
+
+// Queue lists all the tracks in the queue of the speaker.
+func (s *SonosSpeaker) Queue() ([]TrackInfo, error) {
+	objects, err := s.browse("Q:0")
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]TrackInfo, 0, len(objects))
+	for _, o := range objects {
+		t := o.trackInfo()
+		t.AlbumArtURI = s.absoluteURI(t.AlbumArtURI)
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+// absoluteURI resolves URIs that the speaker hands out relative to itself, like the album art.
+func (s *SonosSpeaker) absoluteURI(uri string) string {
+	if uri == "" {
+		return ""
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return s.zone().base.ResolveReference(u).String()
+}
+
+// Favorites lists both the Sonos favourites and the saved Sonos playlists.
+func (s *SonosSpeaker) Favorites() ([]Favorite, error) {
+	favs, err := s.browse("FV:2")
+	if err != nil {
+		return nil, err
+	}
+	lists, err := s.browse("SQ:")
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Favorite
+	for _, f := range favs {
+		res = append(res, Favorite{ID: f.ID, Title: f.Title, URI: f.Res, Metadata: f.ResMD})
+	}
+	for _, l := range lists {
+		m, err := createDidl(l.ID, playlistClass, "RINCON_AssociatedZPUDN")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Favorite{ID: l.ID, Title: l.Title, URI: l.Res, Metadata: string(m)})
+	}
+	return res, nil
+}
+
+// Favorite looks up a single favourite or saved playlist by its ID.
+func (s *SonosSpeaker) Favorite(id string) (*Favorite, error) {
+	favs, err := s.Favorites()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range favs {
+		if f.ID == id {
+			return &f, nil
+		}
+	}
+	return nil, fmt.Errorf("no favorite with id %v found on %v", id, s.zone().name)
+}
+
+// browse fetches all the direct children of the given object in the content directory, one page at a time.
+func (s *SonosSpeaker) browse(objectId string) ([]didlObject, error) {
+	var objects []didlObject
+	for {
+		in := struct {
+			ObjectID       string
+			BrowseFlag     string
+			Filter         string
+			StartingIndex  string
+			RequestedCount string
+			SortCriteria   string
+		}{
+			objectId,
+			"BrowseDirectChildren",
+			"*",
+			strconv.Itoa(len(objects)),
+			strconv.Itoa(browsePageSize),
+			"",
+		}
+		out := struct {
+			Result         string
+			NumberReturned string
+			TotalMatches   string
+		}{}
+		if err := s.zone().content.Action("Browse", in, &out); err != nil {
+			return nil, fmt.Errorf("could not browse %v: %v", objectId, err)
+		}
+
+		var result didlResult
+		if err := xml.Unmarshal([]byte(out.Result), &result); err != nil {
+			return nil, fmt.Errorf("could not parse the content of %v: %v", objectId, err)
+		}
+		objects = append(objects, result.Items...)
+		objects = append(objects, result.Containers...)
+
+		returned, _ := strconv.Atoi(out.NumberReturned)
+		total, _ := strconv.Atoi(out.TotalMatches)
+		if returned == 0 || len(objects) >= total {
+			return objects, nil
+		}
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/callebjorkell/rpi-nfc-player/library"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
+	"image"
+	"time"
 )
 
 type TrackLocation int
@@ -13,10 +17,26 @@ type TrackType int
 type CardInfo struct {
 	// The ID of the card itself
 	ID string `json:"id"`
+	// Service is the name of the music service that the album/playlist is played from. Empty means Deezer.
+	Service string `json:"service,omitempty"`
 	// AlbumID contains the Deezer album ID if applicable
 	AlbumID *uint64 `json:"albumId,omitempty"`
 	// PlaylistID contains the Deezer playlist ID if applicable
 	PlaylistID *uint64 `json:"playlistId,omitempty"`
+	// ServiceAlbumID contains the album ID for services other than Deezer if applicable
+	ServiceAlbumID string `json:"serviceAlbumId,omitempty"`
+	// ServicePlaylistID contains the playlist ID for services other than Deezer if applicable
+	ServicePlaylistID string `json:"servicePlaylistId,omitempty"`
+	// LocalPath contains the path of a local album directory, relative to the library root, if applicable
+	LocalPath string `json:"localPath,omitempty"`
+	// Stream contains the internet radio station if applicable. Radio cards have no state.
+	Stream *radio.Station `json:"stream,omitempty"`
+	// Favorite contains the Sonos favorite or saved Sonos playlist if applicable
+	Favorite *Favorite `json:"favorite,omitempty"`
+	// Action is set for control cards, that do something with the player instead of playing music.
+	Action *Action `json:"action,omitempty"`
+	// SleepTimer is how long the card should play before stopping, like "30m0s". Empty means no timer.
+	SleepTimer string `json:"sleepTimer,omitempty"`
 	// State is the last seen state of the card. If none exists, the state will be nil.
 	State *CardStatus `json:"state,omitempty"`
 	// Title is the human readable title of the album/playlist on the card. Helps in debugging.
@@ -27,14 +47,45 @@ func (p CardInfo) AlbumIDString() string {
 	if p.AlbumID != nil {
 		return fmt.Sprintf("%v", *p.AlbumID)
 	}
-	return ""
+	return p.ServiceAlbumID
 }
 
 func (p CardInfo) PlaylistIDString() string {
 	if p.PlaylistID != nil {
 		return fmt.Sprintf("%v", *p.PlaylistID)
 	}
-	return ""
+	return p.ServicePlaylistID
+}
+
+// Resumable tells if the card plays from the queue, which means that there is state worth saving when it is removed.
+func (p CardInfo) Resumable() bool {
+	if p.Stream != nil || p.Action != nil {
+		return false
+	}
+	if p.Favorite != nil {
+		return p.Favorite.Queueable()
+	}
+	return true
+}
+
+// SleepDuration parses the sleep timer of the card. Returns 0 if there is no (valid) timer.
+func (p CardInfo) SleepDuration() time.Duration {
+	if p.SleepTimer == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(p.SleepTimer)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// ServiceName returns the name of the music service that the card belongs to.
+func (p CardInfo) ServiceName() string {
+	if p.Service == "" {
+		return defaultService
+	}
+	return p.Service
 }
 
 func (p CardInfo) String() string {
@@ -64,12 +115,27 @@ func FromAlbum(album *deezer.Album, cardId string) *CardInfo {
 }
 
 func (p CardInfo) ToPlayable() (deezer.Playable, error) {
+	if p.ServiceName() != defaultService {
+		return serviceItem{card: p}, nil
+	}
 	if p.AlbumID != nil {
 		return deezer.GetAlbum(p.AlbumIDString())
 	}
 	if p.PlaylistID != nil {
 		return deezer.GetPlaylist(p.PlaylistIDString())
 	}
+	if p.LocalPath != "" {
+		return library.GetAlbum(p.LocalPath)
+	}
+	if p.Stream != nil {
+		return p.Stream, nil
+	}
+	if p.Favorite != nil {
+		return serviceItem{card: p}, nil
+	}
+	if p.Action != nil {
+		return *p.Action, nil
+	}
 	return nil, errors.New("")
 }
 
@@ -82,4 +148,91 @@ func FromPlaylist(p *deezer.Playlist, cardId string) *CardInfo {
 		AlbumID:    nil,
 		Title:      p.FullTitle(),
 	}
-}
\ No newline at end of file
+}
+
+func FromLocal(a *library.Album, cardId string) *CardInfo {
+	return &CardInfo{
+		ID:        cardId,
+		LocalPath: a.Path,
+		State:     nil,
+		Title:     a.FullTitle(),
+	}
+}
+
+func FromStation(s *radio.Station, cardId string) *CardInfo {
+	return &CardInfo{
+		ID:     cardId,
+		Stream: s,
+		State:  nil,
+		Title:  s.FullTitle(),
+	}
+}
+
+func FromService(service, albumId, playlistId, title, cardId string) (*CardInfo, error) {
+	s, err := GetService(service)
+	if err != nil {
+		return nil, err
+	}
+	if albumId == "" && playlistId == "" {
+		return nil, errors.New("one of album or playlist ID is needed")
+	}
+	return &CardInfo{
+		ID:                cardId,
+		Service:           s.Name,
+		ServiceAlbumID:    albumId,
+		ServicePlaylistID: playlistId,
+		State:             nil,
+		Title:             title,
+	}, nil
+}
+
+func FromAction(a *Action, cardId string) *CardInfo {
+	return &CardInfo{
+		ID:     cardId,
+		Action: a,
+		State:  nil,
+		Title:  a.Title(),
+	}
+}
+
+func FromFavorite(f *Favorite, cardId string) *CardInfo {
+	return &CardInfo{
+		ID:       cardId,
+		Favorite: f,
+		State:    nil,
+		Title:    f.Title,
+	}
+}
+
+// serviceItem is what is used to create labels for cards where metadata can not be looked up, like other music
+// services and Sonos favorites. All that is known is the title that was stored on the card.
+type serviceItem struct {
+	card CardInfo
+}
+
+func (s serviceItem) String() string {
+	if s.card.Favorite != nil {
+		return s.card.Favorite.String()
+	}
+	return fmt.Sprintf("Type: %v, album: %v, playlist: %v, title: %v", s.card.ServiceName(), s.card.ServiceAlbumID, s.card.ServicePlaylistID, s.card.Title)
+}
+
+func (s serviceItem) CoverArt() *image.Image {
+	return deezer.DefaultCoverArt()
+}
+
+func (s serviceItem) Id() string {
+	return fmt.Sprintf("c-%v", s.card.ID)
+}
+
+func (s serviceItem) Artist() string {
+	return ""
+}
+
+func (s serviceItem) Title() string {
+	return s.card.Title
+}
+
+func (s serviceItem) FullTitle() string {
+	return s.Title()
+}
@@ -0,0 +1,110 @@
+package sonos
+
+import (
+	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/fogleman/gg"
+	"image"
+	"math"
+)
+
+// Icons that can be drawn with actionIcon, and the color of the circle that they are drawn on.
+const (
+	iconShuffle    = "shuffle"
+	iconVolumeUp   = "volume-up"
+	iconVolumeDown = "volume-down"
+	iconStop       = "stop"
+	iconSleep      = "sleep"
+	iconSpeaker    = "speaker"
+)
+
+var iconColors = map[string]string{
+	iconShuffle:    "#0048BA",
+	iconVolumeUp:   "#32CD32",
+	iconVolumeDown: "#8A2BE2",
+	iconStop:       "#8A2BE2",
+	iconSleep:      "#FF7E00",
+	iconSpeaker:    "#0048BA",
+}
+
+const iconSize = 800
+
+// actionIcon draws the artwork for cards that don't play anything, but control the player instead. Unknown icons
+// give the default art.
+func actionIcon(icon string) *image.Image {
+	color, ok := iconColors[icon]
+	if !ok {
+		return deezer.DefaultCoverArt()
+	}
+	c := gg.NewContext(iconSize, iconSize)
+	c.SetRGB(1, 1, 1)
+	c.Clear()
+
+	c.SetHexColor(color)
+	c.DrawCircle(iconSize/2, iconSize/2, iconSize/2-20)
+	c.Fill()
+
+	c.SetRGB(1, 1, 1)
+	c.SetLineWidth(50)
+	c.SetLineCap(gg.LineCapRound)
+	c.SetLineJoin(gg.LineJoinRound)
+
+	switch icon {
+	case iconShuffle:
+		drawArrow(c, 200, 550, 600, 250)
+		drawArrow(c, 200, 250, 600, 550)
+	case iconVolumeUp:
+		drawSpeaker(c, 160)
+		c.DrawLine(470, 400, 650, 400)
+		c.DrawLine(560, 310, 560, 490)
+		c.Stroke()
+	case iconVolumeDown:
+		drawSpeaker(c, 160)
+		c.DrawLine(470, 400, 650, 400)
+		c.Stroke()
+	case iconStop:
+		c.DrawRectangle(250, 250, 300, 300)
+		c.Fill()
+	case iconSleep:
+		drawZ(c, 180, 420, 220)
+		drawZ(c, 440, 300, 150)
+		drawZ(c, 600, 220, 90)
+	case iconSpeaker:
+		drawSpeaker(c, 180)
+		for i, r := range []float64{110, 200} {
+			c.DrawArc(400, 400, r+float64(i)*10, -math.Pi/4, math.Pi/4)
+			c.Stroke()
+		}
+	}
+
+	img := c.Image()
+	return &img
+}
+
+func drawArrow(c *gg.Context, x1, y1, x2, y2 float64) {
+	c.DrawLine(x1, y1, x2, y2)
+	angle := math.Atan2(y2-y1, x2-x1)
+	for _, side := range []float64{-1, 1} {
+		a := angle + math.Pi + side*math.Pi/5
+		c.DrawLine(x2, y2, x2+90*math.Cos(a), y2+90*math.Sin(a))
+	}
+	c.Stroke()
+}
+
+func drawSpeaker(c *gg.Context, x float64) {
+	c.MoveTo(x, 320)
+	c.LineTo(x+90, 320)
+	c.LineTo(x+220, 210)
+	c.LineTo(x+220, 590)
+	c.LineTo(x+90, 480)
+	c.LineTo(x, 480)
+	c.ClosePath()
+	c.Fill()
+}
+
+func drawZ(c *gg.Context, x, y, size float64) {
+	c.MoveTo(x, y)
+	c.LineTo(x+size, y)
+	c.LineTo(x, y+size)
+	c.LineTo(x+size, y+size)
+	c.Stroke()
+}
@@ -3,9 +3,10 @@ package sonos
 import (
 	"encoding/xml"
 	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/library"
 )
 
-const deezerServiceId = "SA_RINCON519_X_#Svc519-0-Token"
+const radioServiceId = "SA_RINCON65031_"
 
 type didlDesc struct {
 	ID        string `xml:"id,attr"`
@@ -13,13 +14,22 @@ type didlDesc struct {
 	Value     string `xml:",chardata"`
 }
 
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Value        string `xml:",chardata"`
+}
+
 type didlItem struct {
-	ID         string   `xml:"id,attr"`
-	ParentID   string   `xml:"parentID,attr"`
-	Restricted string   `xml:"restricted,attr"`
-	Title      string   `xml:"dc:title"`
-	Class      string   `xml:"upnp:class"`
-	Desc       didlDesc `xml:"desc"`
+	ID          string    `xml:"id,attr"`
+	ParentID    string    `xml:"parentID,attr"`
+	Restricted  string    `xml:"restricted,attr"`
+	Res         *didlRes  `xml:"res,omitempty"`
+	Title       string    `xml:"dc:title"`
+	Creator     string    `xml:"dc:creator,omitempty"`
+	Album       string    `xml:"upnp:album,omitempty"`
+	TrackNumber int       `xml:"upnp:originalTrackNumber,omitempty"`
+	Class       string    `xml:"upnp:class"`
+	Desc        *didlDesc `xml:"desc,omitempty"`
 }
 
 type didlPayload struct {
@@ -36,32 +46,59 @@ const (
  	trackClass = "object.item.audioItem.musicTrack"
  	albumClass = "object.container.album"
  	playlistClass = "object.container.playlistContainer"
+	radioClass    = "object.item.audioItem.audioBroadcast"
 )
 
-func CreateTrackMetadata(deezerId string) ([]byte, error) {
-	return createDidl(fmt.Sprintf("00032020%v", deezerId), trackClass)
-}
-
-func CreateAlbumMetadata(deezerId uint64) ([]byte, error) {
-	return createDidl(fmt.Sprintf("0004206calbum-%v", deezerId), albumClass)
-}
-
-func CreatePlaylistMetadata(deezerId uint64) ([]byte, error) {
-	return createDidl(fmt.Sprintf("0006206cplaylist_spotify%%3aplaylist-%v", deezerId), playlistClass)
-}
-
-func createDidl(ID, class string) ([]byte, error) {
+func createDidl(ID, class, serviceId string) ([]byte, error) {
 	item := &didlItem{
 		ID:         ID,
 		ParentID:   "-1",
 		Restricted: "true",
 		Class:      class,
-		Desc: didlDesc{
+		Desc: &didlDesc{
 			ID:        "cdudn",
 			NameSpace: "urn:schemas-rinconnetworks-com:metadata-1-0/",
-			Value:     deezerServiceId,
+			Value:     serviceId,
 		},
 	}
+	return marshalDidl(item)
+}
+
+// CreateLocalTrackMetadata creates the metadata for a track that the speaker fetches directly from the given URI.
+func CreateLocalTrackMetadata(t library.Track, uri string) ([]byte, error) {
+	return marshalDidl(&didlItem{
+		ID:         "-1",
+		ParentID:   "-1",
+		Restricted: "true",
+		Res: &didlRes{
+			ProtocolInfo: fmt.Sprintf("http-get:*:%v:*", t.MimeType),
+			Value:        uri,
+		},
+		Title:       t.Title,
+		Creator:     t.Artist,
+		Album:       t.Album,
+		TrackNumber: t.Number,
+		Class:       trackClass,
+	})
+}
+
+// CreateStreamMetadata creates the metadata for an internet radio stream with the given name.
+func CreateStreamMetadata(name string) ([]byte, error) {
+	return marshalDidl(&didlItem{
+		ID:         "R:0/0/0",
+		ParentID:   "R:0/0",
+		Restricted: "true",
+		Title:      name,
+		Class:      radioClass,
+		Desc: &didlDesc{
+			ID:        "cdudn",
+			NameSpace: "urn:schemas-rinconnetworks-com:metadata-1-0/",
+			Value:     radioServiceId,
+		},
+	})
+}
+
+func marshalDidl(item *didlItem) ([]byte, error) {
 	didl := didlPayload{
 		XMLName: xml.Name{Local: "DIDL-Lite"},
 		Dc:      "http://purl.org/dc/elements/1.1/",
@@ -70,7 +107,7 @@ func createDidl(ID, class string) ([]byte, error) {
 		Ns:      "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
 	}
 
-	if class == trackClass {
+	if item.Class == trackClass || item.Class == radioClass {
 		didl.Item = item
 	} else {
 		didl.Container = item
@@ -0,0 +1,18 @@
+package sonos
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	actionSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sonos_action_seconds",
+		Help:    "How long UPnP actions on the speaker take.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 10),
+	}, []string{"action"})
+	actionFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sonos_action_failures_total",
+		Help: "UPnP actions on the speaker that failed.",
+	}, []string{"action"})
+)
@@ -0,0 +1,117 @@
+package sonos
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MusicService describes how albums and playlists from one of the music services that Sonos supports are referenced
+// when queueing them on a speaker.
+type MusicService struct {
+	// Name is the name that the service is referred to by on the cards.
+	Name string
+	// serviceType is the Sonos service type, which is the service ID * 256 + 7.
+	serviceType int
+	// accountToken identifies the account that the speaker should play the content with.
+	accountToken string
+	// album, playlist and track are the formats of the item IDs, with the ID of the item on the service as the only
+	// parameter.
+	album    string
+	playlist string
+	track    string
+}
+
+const defaultService = "deezer"
+
+var services = map[string]*MusicService{
+	"deezer": {
+		Name:        "deezer",
+		serviceType: 519,
+		album:       "0004206calbum-%v",
+		playlist:    "0006206cplaylist_spotify%%3aplaylist-%v",
+		track:       "00032020%v",
+	},
+	"spotify": {
+		Name:        "spotify",
+		serviceType: 2311,
+		album:       "1004206cspotify%%3aalbum%%3a%v",
+		playlist:    "1006206cspotify%%3aplaylist%%3a%v",
+		track:       "00032020spotify%%3atrack%%3a%v",
+	},
+	"apple": {
+		Name:        "apple",
+		serviceType: 52231,
+		album:       "1004206calbum%%3a%v",
+		playlist:    "1006206cplaylist%%3a%v",
+		track:       "10032020song%%3a%v",
+	},
+	"amazon": {
+		Name:        "amazon",
+		serviceType: 51463,
+		album:       "1004206ccatalog%%2falbums%%2f%v%%2f%%23album_desc",
+		playlist:    "1006206ccatalog%%2fplaylists%%2f%v%%2f%%23playlist_desc",
+		track:       "10032020catalog%%2ftracks%%2f%v%%2f%%23track_desc",
+	},
+}
+
+// ServiceNames returns the names of all the supported music services.
+func ServiceNames() []string {
+	var names []string
+	for n := range services {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetService fetches the music service with the given name. An empty name gives Deezer, since that was the only
+// service supported before cards started recording which service they belong to.
+func GetService(name string) (*MusicService, error) {
+	if name == "" {
+		name = defaultService
+	}
+	s, ok := services[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown music service %v", name)
+	}
+	return s, nil
+}
+
+// SetAccountToken sets the account token that the speaker should use for the given service. The token is found in
+// the desc element of the metadata of anything from the service that is already playing, and looks something like
+// SA_RINCON2311_X_#Svc2311-0-Token.
+func SetAccountToken(name, token string) error {
+	s, err := GetService(name)
+	if err != nil {
+		return err
+	}
+	s.accountToken = token
+	return nil
+}
+
+func (m *MusicService) token() string {
+	if m.accountToken != "" {
+		return m.accountToken
+	}
+	return fmt.Sprintf("SA_RINCON%d_X_#Svc%d-0-Token", m.serviceType, m.serviceType)
+}
+
+func (m *MusicService) AlbumURI(id string) string {
+	return "x-rincon-cpcontainer:" + fmt.Sprintf(m.album, id)
+}
+
+func (m *MusicService) PlaylistURI(id string) string {
+	return "x-rincon-cpcontainer:" + fmt.Sprintf(m.playlist, id)
+}
+
+func (m *MusicService) TrackMetadata(id string) ([]byte, error) {
+	return createDidl(fmt.Sprintf(m.track, id), trackClass, m.token())
+}
+
+func (m *MusicService) AlbumMetadata(id string) ([]byte, error) {
+	return createDidl(fmt.Sprintf(m.album, id), albumClass, m.token())
+}
+
+func (m *MusicService) PlaylistMetadata(id string) ([]byte, error) {
+	return createDidl(fmt.Sprintf(m.playlist, id), playlistClass, m.token())
+}
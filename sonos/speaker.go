@@ -2,12 +2,22 @@ package sonos
 
 import (
 	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/deezer"
+	"github.com/callebjorkell/rpi-nfc-player/library"
+	"github.com/callebjorkell/rpi-nfc-player/logging"
+	"github.com/callebjorkell/rpi-nfc-player/radio"
 	"github.com/huin/goupnp"
 	"github.com/huin/goupnp/soap"
-	"github.com/sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+var log = logging.For("sonos")
+
 /*
  * I would probably have given up on trying to figure out most of this stuff if it wasn't for the excellent PHP library
  * made by Craig Duncan (https://github.com/duncan3dc/sonos). This served as a blueprint for what to pass and where
@@ -15,32 +25,46 @@ import (
  */
 
 type SonosSpeaker struct {
-	control *service
-	content *service
-	info    *service
-	name    string
-	uid     string
+	// lock guards the zone, which is swapped when switching to another speaker, and the shuffle setting. Both are
+	// used from more than one goroutine.
+	lock    sync.RWMutex
+	current *zone
+	shuffle bool
+	library *library.Server
+}
+
+// zone is the speaker of a zone, and the services that it's controlled through.
+type zone struct {
+	control   *service
+	content   *service
+	info      *service
+	rendering *service
+	name      string
+	uid       string
+	base      url.URL
 }
 
 type State struct {
 	Track         string
 	TrackDuration string
+	TrackMetaData string
+	TrackURI      string
 	RelTime       string
 }
 
 func New(name string) (*SonosSpeaker, error) {
 	d, err := goupnp.DiscoverDevices("urn:schemas-upnp-org:device:ZonePlayer:1")
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, err
 	}
-	logrus.Debugf("Inspecting %v devices", len(d))
+	log.Debugf("Inspecting %v devices", len(d))
 	for _, dev := range d {
 		root, err := goupnp.DeviceByURL(dev.Location)
 		if err != nil {
-			logrus.Errorf("Could not retrieve %v, speaker went away?", dev.Location)
+			log.Errorf("Could not retrieve %v, speaker went away?", dev.Location)
 			continue
 		}
-		logrus.Debugf("Checking device: %v", root.Device.FriendlyName)
+		log.Debugf("Checking device: %v", root.Device.FriendlyName)
 
 		s, err := getService(root, "DeviceProperties")
 		if err != nil {
@@ -62,48 +86,94 @@ func New(name string) (*SonosSpeaker, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &SonosSpeaker{
+			rendering, err := getService(root, "RenderingControl")
+			if err != nil {
+				return nil, err
+			}
+			return &SonosSpeaker{current: &zone{
 				control,
 				content,
 				s,
+				rendering,
 				name,
 				root.Device.UDN[5:], // trim away the "uuid:" prefix
-			}, nil
+				root.URLBase,
+			}}, nil
 		}
 	}
 	return nil, fmt.Errorf("no speakers found for zone %v", name)
 }
 
+// zone is the zone that the speaker controls right now.
+func (s *SonosSpeaker) zone() *zone {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.current
+}
+
 func (s *SonosSpeaker) setAVTransportToQueue() {
+	if err := s.setAVTransportURI(fmt.Sprintf("x-rincon-queue:%v#0", s.zone().uid), ""); err != nil {
+		log.Warn("Could not properly set the queue as the AV soure: ", err)
+	}
+}
+
+func (s *SonosSpeaker) setAVTransportURI(uri, metadata string) error {
 	in := struct {
 		InstanceID         string
 		CurrentURI         string
 		CurrentURIMetaData string
 	}{
 		"0",
-		fmt.Sprintf("x-rincon-queue:%v#0", s.uid),
-		"",
+		uri,
+		metadata,
 	}
+	return s.zone().control.Action("SetAVTransportURI", in, nil)
+}
 
-	if err := s.control.Action("SetAVTransportURI", in, nil); err != nil {
-		logrus.Warn("Could not properly set the queue as the AV soure: ", err)
-	}
+// UseLibrary sets the server that local albums are served from. Without one, local albums can not be played.
+func (s *SonosSpeaker) UseLibrary(l *library.Server) {
+	s.library = l
 }
 
 // SetPlaylist clears the queue and then adds the given playlist for the speaker. Will use the order:
 // * Album
 // * Playlist
-// * Tracks
-// and use the first one that has been set. Repeat will also be set.
+// * Local album
+// * Sonos favorite
+// and use the first one that has been set. Repeat will also be set. Radio cards, and favorites that can not be queued,
+// leave the queue alone and are played directly instead.
 func (s *SonosSpeaker) SetPlaylist(playlist CardInfo) {
+	if playlist.Stream != nil {
+		s.PlayStream(*playlist.Stream)
+		return
+	}
+	if playlist.Favorite != nil && !playlist.Favorite.Queueable() {
+		log.Debugf("Playing favorite %v directly", playlist.Favorite.ID)
+		if err := s.setAVTransportURI(playlist.Favorite.URI, playlist.Favorite.Metadata); err != nil {
+			log.Warn("Could not set the favorite as the AV source: ", err)
+		}
+		return
+	}
+
 	s.Clear()
 
-	if playlist.AlbumID != nil {
-		s.playAlbum(*playlist.AlbumID)
-	} else if playlist.PlaylistID != nil {
-		s.playPlaylist(*playlist.PlaylistID)
+	svc, err := GetService(playlist.Service)
+	if err != nil {
+		log.Errorf("Can not play playlist %v: %v", playlist.ID, err)
+		return
+	}
+
+	if id := playlist.AlbumIDString(); id != "" {
+		s.playAlbum(svc, id)
+	} else if id := playlist.PlaylistIDString(); id != "" {
+		s.playPlaylist(svc, id)
+	} else if playlist.LocalPath != "" {
+		s.playLocal(playlist.LocalPath)
+	} else if playlist.Favorite != nil {
+		log.Debug("Queueing favorite ", playlist.Favorite.ID)
+		s.enqueue(playlist.Favorite.URI, []byte(playlist.Favorite.Metadata))
 	} else {
-		logrus.Errorf("No content for playlist %v. Try to re-provision it?", playlist.ID)
+		log.Errorf("No content for playlist %v. Try to re-provision it?", playlist.ID)
 	}
 
 	s.setAVTransportToQueue()
@@ -111,31 +181,204 @@ func (s *SonosSpeaker) SetPlaylist(playlist CardInfo) {
 	s.SetRepeat(true)
 
 	if playlist.State != nil {
-		logrus.Debugf("Resuming the previous state from track %v", playlist.State.CurrentTrack)
+		log.Debugf("Resuming the previous state from track %v", playlist.State.CurrentTrack)
 		s.Seek(playlist.State.CurrentTrack)
 	}
 }
 
-func (s *SonosSpeaker) playAlbum(id uint64) {
-	logrus.Debug("Queueing album ", id)
-	m, err := CreateAlbumMetadata(id)
+// HasLoaded tells if the speaker is playing from a queue that holds exactly the tracks of the given card. If so,
+// there is no need to set the playlist again.
+func (s *SonosSpeaker) HasLoaded(playlist CardInfo) bool {
+	if !playlist.Resumable() {
+		return false
+	}
+	expected, ok := s.expectedQueue(playlist)
+	if !ok {
+		return false
+	}
+
+	q, err := s.Queue()
 	if err != nil {
-		logrus.Warn("Unable to generate DIDL: ", err)
+		log.Debug("Could not read the queue: ", err)
+		return false
+	}
+	uris := trackURIs(q)
+	if len(uris) != len(expected) {
+		return false
+	}
+	for i := range uris {
+		if withoutQuery(uris[i]) != withoutQuery(expected[i]) {
+			return false
+		}
+	}
+
+	// the queue is the same, but make sure that the speaker is actually playing from it
+	state, err := s.MediaInfo()
+	if err != nil {
+		return false
+	}
+	track, err := strconv.Atoi(state.Track)
+	if err != nil || track < 1 || track > len(uris) {
+		return false
+	}
+	return uris[track-1] == state.TrackURI
+}
+
+// Resume continues playing a playlist that is already loaded, only seeking if the speaker is not on the track that
+// the card was last on.
+func (s *SonosSpeaker) Resume(playlist CardInfo) {
+	target := 1
+	if playlist.State != nil {
+		target = playlist.State.CurrentTrack
+	}
+	if state, err := s.MediaInfo(); err != nil || state.Track != strconv.Itoa(target) {
+		log.Debugf("Resuming from track %v", target)
+		s.Seek(target)
+	}
+	s.Play()
+}
+
+// deezerTrackURI is the format of the URIs of the Deezer tracks in the queue, with the ID of the track as the only
+// parameter. The speaker adds the details of the account as the query.
+const deezerTrackURI = "x-sonos-http:tr%%3a%v.mp3"
+
+// savedQueuePrefix is what the URI of a saved Sonos playlist starts with, followed by its number.
+const savedQueuePrefix = "file:///jffs/settings/savedqueues.rsq#"
+
+// expectedQueue looks up the URIs of the tracks that setting the playlist of the card puts in the queue. ok is false
+// when they can't be known, like for the albums of services other than Deezer, which can only be looked up by the
+// speaker itself.
+func (s *SonosSpeaker) expectedQueue(playlist CardInfo) (uris []string, ok bool) {
+	switch {
+	case playlist.AlbumIDString() != "" || playlist.PlaylistIDString() != "":
+		if playlist.ServiceName() != defaultService {
+			return nil, false
+		}
+		var ids []uint64
+		var err error
+		if id := playlist.AlbumIDString(); id != "" {
+			ids, err = deezer.GetAlbumTracks(id)
+		} else {
+			ids, err = deezer.GetPlaylistTracks(playlist.PlaylistIDString())
+		}
+		if err != nil {
+			log.Debug("Could not look up the tracks of the card: ", err)
+			return nil, false
+		}
+		for _, id := range ids {
+			uris = append(uris, fmt.Sprintf(deezerTrackURI, id))
+		}
+	case playlist.LocalPath != "":
+		if s.library == nil {
+			return nil, false
+		}
+		a, err := library.GetAlbum(playlist.LocalPath)
+		if err != nil {
+			log.Debug("Could not read the local album: ", err)
+			return nil, false
+		}
+		for _, t := range a.Tracks {
+			uris = append(uris, s.library.URL(t.Path))
+		}
+	case playlist.Favorite != nil && strings.HasPrefix(playlist.Favorite.URI, savedQueuePrefix):
+		objects, err := s.browse("SQ:" + strings.TrimPrefix(playlist.Favorite.URI, savedQueuePrefix))
+		if err != nil {
+			log.Debug("Could not read the saved playlist: ", err)
+			return nil, false
+		}
+		for _, o := range objects {
+			uris = append(uris, o.Res)
+		}
+	default:
+		return nil, false
+	}
+	return uris, true
+}
+
+func withoutQuery(uri string) string {
+	return strings.SplitN(uri, "?", 2)[0]
+}
+
+func trackURIs(tracks []TrackInfo) []string {
+	uris := make([]string, len(tracks))
+	for i, t := range tracks {
+		uris[i] = t.URI
+	}
+	return uris
+}
+
+func (s *SonosSpeaker) playAlbum(svc *MusicService, id string) {
+	log.Debugf("Queueing %v album %v", svc.Name, id)
+	m, err := svc.AlbumMetadata(id)
+	if err != nil {
+		log.Warn("Unable to generate DIDL: ", err)
 		return
 	}
-	uri := fmt.Sprintf("x-rincon-cpcontainer:0004206calbum-%v", id)
-	s.enqueue(uri, m)
+	s.enqueue(svc.AlbumURI(id), m)
 }
 
-func (s *SonosSpeaker) playPlaylist(id uint64) {
-	logrus.Debug("Queueing playlist ", id)
-	m, err := CreatePlaylistMetadata(id)
+func (s *SonosSpeaker) playPlaylist(svc *MusicService, id string) {
+	log.Debugf("Queueing %v playlist %v", svc.Name, id)
+	m, err := svc.PlaylistMetadata(id)
 	if err != nil {
-		logrus.Warn("Unable to generate DIDL: ", err)
+		log.Warn("Unable to generate DIDL: ", err)
 		return
 	}
-	uri := fmt.Sprintf("x-rincon-cpcontainer:0006206cplaylist_spotify%%3aplaylist-%v", id)
-	s.enqueue(uri, m)
+	s.enqueue(svc.PlaylistURI(id), m)
+}
+
+func (s *SonosSpeaker) playLocal(path string) {
+	log.Debug("Queueing local album ", path)
+	if s.library == nil {
+		log.Errorf("Can not play local album %v, the library is not being served", path)
+		return
+	}
+	a, err := library.GetAlbum(path)
+	if err != nil {
+		log.Error("Unable to read the local album: ", err)
+		return
+	}
+	for _, t := range a.Tracks {
+		uri := s.library.URL(t.Path)
+		m, err := CreateLocalTrackMetadata(t, uri)
+		if err != nil {
+			log.Warn("Unable to generate DIDL: ", err)
+			return
+		}
+		s.enqueue(uri, m)
+	}
+}
+
+// PlayStream sets the given radio station as the source of the speaker. The queue is left untouched.
+func (s *SonosSpeaker) PlayStream(station radio.Station) {
+	log.Debugf("Setting stream %v (%v)", station.Name, station.URL)
+	m, err := CreateStreamMetadata(station.Name)
+	if err != nil {
+		log.Warn("Unable to generate DIDL: ", err)
+		return
+	}
+	uri, err := streamURI(station.URL)
+	if err != nil {
+		log.Warnf("Invalid stream URL %v: %v", station.URL, err)
+		return
+	}
+	if err := s.setAVTransportURI(uri, string(m)); err != nil {
+		log.Warn("Could not set the stream as the AV source: ", err)
+	}
+}
+
+// streamURI is the URI that the speaker plays a radio stream from. The speaker decides how to connect to it, so the
+// scheme of the stream URL, be it http or https, is left out.
+func streamURI(stream string) (string, error) {
+	u, err := url.Parse(stream)
+	if err != nil {
+		return "", err
+	}
+	uri := "x-rincon-mp3radio://" + u.Host + u.EscapedPath()
+	if u.RawQuery != "" {
+		uri += "?" + u.RawQuery
+	}
+	return uri, nil
 }
 
 func (s *SonosSpeaker) enqueue(uri string, m []byte) {
@@ -148,7 +391,7 @@ func (s *SonosSpeaker) enqueue(uri string, m []byte) {
 	}{
 		"0", uri, string(m), "0", "0",
 	}
-	s.control.Action("AddURIToQueue", in, nil)
+	s.zone().control.Action("AddURIToQueue", in, nil)
 }
 
 func (s *SonosSpeaker) Seek(position int) {
@@ -162,13 +405,41 @@ func (s *SonosSpeaker) Seek(position int) {
 		strconv.Itoa(position),
 	}
 
-	s.control.Action("Seek", in, nil)
+	s.zone().control.Action("Seek", in, nil)
+}
+
+// Switch makes this speaker control another zone instead. The library and shuffle setting are kept.
+func (s *SonosSpeaker) Switch(name string) error {
+	n, err := New(name)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current = n.current
+	return nil
+}
+
+// SetShuffle turns shuffling on or off. The setting is kept when new playlists are set.
+func (s *SonosSpeaker) SetShuffle(shuffle bool) {
+	s.lock.Lock()
+	s.shuffle = shuffle
+	s.lock.Unlock()
+	s.SetRepeat(true)
 }
 
 func (s *SonosSpeaker) SetRepeat(repeat bool) {
+	s.lock.RLock()
+	shuffle := s.shuffle
+	s.lock.RUnlock()
+
 	mode := "NORMAL"
-	if repeat {
+	if repeat && shuffle {
+		mode = "SHUFFLE"
+	} else if repeat {
 		mode = "REPEAT_ALL"
+	} else if shuffle {
+		mode = "SHUFFLE_NOREPEAT"
 	}
 	in := struct {
 		InstanceID  string
@@ -178,7 +449,7 @@ func (s *SonosSpeaker) SetRepeat(repeat bool) {
 		mode, // or NORMAL
 	}
 
-	s.control.Action("SetPlayMode", in, nil)
+	s.zone().control.Action("SetPlayMode", in, nil)
 }
 
 func (s *SonosSpeaker) Play() {
@@ -189,7 +460,7 @@ func (s *SonosSpeaker) Play() {
 		"0",
 		"1",
 	}
-	s.control.Action("Play", in, nil)
+	s.zone().control.Action("Play", in, nil)
 }
 
 func (s *SonosSpeaker) Clear() {
@@ -208,17 +479,21 @@ func (s *SonosSpeaker) Pause() {
 	s.simpleCommand("Pause")
 }
 
+func (s *SonosSpeaker) Stop() {
+	s.simpleCommand("Stop")
+}
+
 func (s *SonosSpeaker) simpleCommand(action string) {
 	in := struct {
 		InstanceID string
 	}{
 		"0",
 	}
-	s.control.Action(action, in, nil)
+	s.zone().control.Action(action, in, nil)
 }
 
 func (s *SonosSpeaker) Name() string {
-	return s.name
+	return s.zone().name
 }
 
 func (s *SonosSpeaker) MediaInfo() (State, error) {
@@ -228,10 +503,95 @@ func (s *SonosSpeaker) MediaInfo() (State, error) {
 		"0",
 	}
 	out := State{}
-	err := s.control.Action("GetPositionInfo", &in, &out)
+	err := s.zone().control.Action("GetPositionInfo", &in, &out)
 	return out, err
 }
 
+// ConfigureSleepTimer makes the speaker stop playing after the given duration. A duration of 0 cancels the timer.
+func (s *SonosSpeaker) ConfigureSleepTimer(d time.Duration) error {
+	duration := ""
+	if d > 0 {
+		d = d.Round(time.Second)
+		duration = fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	in := struct {
+		InstanceID            string
+		NewSleepTimerDuration string
+	}{
+		"0",
+		duration,
+	}
+	return s.zone().control.Action("ConfigureSleepTimer", in, nil)
+}
+
+// RemainingSleepTimer returns the time left until the sleep timer stops the speaker, or 0 if no timer is set.
+func (s *SonosSpeaker) RemainingSleepTimer() (time.Duration, error) {
+	in := struct {
+		InstanceID string
+	}{
+		"0",
+	}
+	out := struct {
+		RemainingSleepTimerDuration string
+	}{}
+	if err := s.zone().control.Action("GetRemainingSleepTimerDuration", in, &out); err != nil {
+		return 0, err
+	}
+	if out.RemainingSleepTimerDuration == "" {
+		return 0, nil
+	}
+
+	var h, m, sec int
+	if _, err := fmt.Sscanf(out.RemainingSleepTimerDuration, "%d:%d:%d", &h, &m, &sec); err != nil {
+		return 0, fmt.Errorf("could not parse the remaining sleep time %v: %v", out.RemainingSleepTimerDuration, err)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second, nil
+}
+
+func (s *SonosSpeaker) Volume() (int, error) {
+	in := struct {
+		InstanceID string
+		Channel    string
+	}{
+		"0",
+		"Master",
+	}
+	out := struct {
+		CurrentVolume string
+	}{}
+	if err := s.zone().rendering.Action("GetVolume", in, &out); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out.CurrentVolume)
+}
+
+func (s *SonosSpeaker) SetVolume(volume int) error {
+	in := struct {
+		InstanceID    string
+		Channel       string
+		DesiredVolume string
+	}{
+		"0",
+		"Master",
+		strconv.Itoa(volume),
+	}
+	return s.zone().rendering.Action("SetVolume", in, nil)
+}
+
+// TransportState returns the state of the speaker, like PLAYING, PAUSED_PLAYBACK or STOPPED.
+func (s *SonosSpeaker) TransportState() (string, error) {
+	in := struct {
+		InstanceID string
+	}{
+		"0",
+	}
+	out := struct {
+		CurrentTransportState string
+	}{}
+	err := s.zone().control.Action("GetTransportInfo", &in, &out)
+	return out.CurrentTransportState, err
+}
+
 func getService(dev *goupnp.RootDevice, id string) (*service, error) {
 	namespace := fmt.Sprintf("urn:schemas-upnp-org:service:%v:1", id)
 	s := dev.Device.FindService(namespace)
@@ -254,5 +614,11 @@ type service struct {
 }
 
 func (s *service) Action(name string, in interface{}, out interface{}) error {
-	return s.SOAPClient.PerformAction(s.namespace, name, in, out)
+	timer := prometheus.NewTimer(actionSeconds.WithLabelValues(name))
+	defer timer.ObserveDuration()
+	err := s.SOAPClient.PerformAction(s.namespace, name, in, out)
+	if err != nil {
+		actionFailuresTotal.WithLabelValues(name).Inc()
+	}
+	return err
 }
@@ -0,0 +1,26 @@
+package sonos
+
+import "testing"
+
+func TestStreamURI(t *testing.T) {
+	tests := []struct {
+		stream string
+		uri    string
+	}{
+		{stream: "http://radio.example.com/live.mp3", uri: "x-rincon-mp3radio://radio.example.com/live.mp3"},
+		{stream: "https://radio.example.com/live.mp3", uri: "x-rincon-mp3radio://radio.example.com/live.mp3"},
+		{
+			stream: "https://radio.example.com:8443/live?type=.mp3",
+			uri:    "x-rincon-mp3radio://radio.example.com:8443/live?type=.mp3",
+		},
+	}
+	for _, test := range tests {
+		uri, err := streamURI(test.stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uri != test.uri {
+			t.Errorf("expected %v to be played from %v, got %v", test.stream, test.uri, uri)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/sonos"
+	log "github.com/sirupsen/logrus"
+)
+
+func showStatus(speaker string, queue bool) {
+	s, err := sonos.New(speaker)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	state, err := s.TransportState()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	info, err := s.MediaInfo()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	t, err := s.NowPlaying()
+	if err != nil {
+		log.Warn(err)
+	}
+
+	fmt.Printf("Speaker: %v (%v)\n", s.Name(), state)
+	fmt.Printf("Playing: %v\n", t)
+	if t.Album != "" {
+		fmt.Printf("Album:   %v\n", t.Album)
+	}
+	fmt.Printf("Track:   %v (%v / %v)\n", info.Track, info.RelTime, info.TrackDuration)
+	if t.AlbumArtURI != "" {
+		fmt.Printf("Art:     %v\n", t.AlbumArtURI)
+	}
+
+	if id, err := db.ReadActive(); err != nil {
+		log.Warn(err)
+	} else if id == "" {
+		fmt.Println("Card:    none")
+	} else if c, err := db.ReadCard(id); err != nil {
+		fmt.Printf("Card:    %v (%v)\n", id, err)
+	} else {
+		fmt.Printf("Card:    %v (%v)\n", c.ID, c.Title)
+	}
+
+	if !queue {
+		return
+	}
+	q, err := s.Queue()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	fmt.Printf("\nQueue (%v tracks):\n", len(q))
+	for i, track := range q {
+		fmt.Printf("%4v │ %v\n", i+1, checkLength(track.String(), 75))
+	}
+}
@@ -2,9 +2,25 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/player"
 	"github.com/callebjorkell/rpi-nfc-player/sonos"
 	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dbFile         = "tracks.db"
+	activeKey      = "player:active"
+	pendingKey     = "player:pending"
+	rulesKey       = "rules:config"
+	overrideKey    = "rules:override"
+	historyPattern = "history:*"
+	// maxHistory is how many plays are kept in the history.
+	maxHistory = 500
 )
 
 type DB struct {
@@ -13,21 +29,32 @@ type DB struct {
 
 // Get a DB, panicking on any error
 func GetDB() *DB {
-	db, err := buntdb.Open("tracks.db")
+	db, err := OpenDB()
 	if err != nil {
 		panic(err)
 	}
+	return db
+}
+
+// OpenDB opens the database.
+func OpenDB() (*DB, error) {
+	db, err := buntdb.Open(dbFile)
+	if err != nil {
+		return nil, err
+	}
 	conf := buntdb.Config{}
 	err = db.ReadConfig(&conf)
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, err
 	}
 	conf.SyncPolicy = buntdb.Always
 	err = db.SetConfig(conf)
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, err
 	}
-	return &DB{instance: db}
+	return &DB{instance: db}, nil
 }
 
 func (db *DB) Close() error {
@@ -51,7 +78,7 @@ func (db *DB) ReadAll() (*[]sonos.CardInfo, error) {
 	var cards []sonos.CardInfo
 	err := db.instance.View(func(tx *buntdb.Tx) error {
 		var shitHappened error
-		err := tx.Ascend("", func(key, value string) bool {
+		err := tx.AscendKeys(getCardKey("*"), func(key, value string) bool {
 			var c sonos.CardInfo
 			shitHappened = json.Unmarshal([]byte(value), &c)
 			if shitHappened != nil {
@@ -75,7 +102,7 @@ func (db *DB) ReadCard(id string) (sonos.CardInfo, error) {
 		s, err := tx.Get(getCardKey(id))
 		if err != nil {
 			if err == buntdb.ErrNotFound {
-				return fmt.Errorf("card %v has not been provisioned", id)
+				return fmt.Errorf("card %v: %w", id, player.ErrUnknownCard)
 			}
 			return err
 		}
@@ -91,6 +118,283 @@ func (db *DB) DeleteCard(id string) error {
 	})
 }
 
+// StoreActive records which card is currently playing, so that other commands can find out. An empty id means that
+// no card is playing.
+func (db *DB) StoreActive(id string) error {
+	return db.instance.Update(func(tx *buntdb.Tx) error {
+		if id == "" {
+			_, err := tx.Delete(activeKey)
+			if err == buntdb.ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		_, _, err := tx.Set(activeKey, id, nil)
+		return err
+	})
+}
+
+// ReadActive fetches the id of the card that is currently playing, or an empty string if none is.
+func (db *DB) ReadActive() (string, error) {
+	var id string
+	err := db.instance.View(func(tx *buntdb.Tx) error {
+		s, err := tx.Get(activeKey)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		id = s
+		return err
+	})
+	return id, err
+}
+
+// StorePending records the unknown cards that have been put on the lid lately.
+func (db *DB) StorePending(cards []player.PendingCard) error {
+	data, err := json.Marshal(cards)
+	if err != nil {
+		return err
+	}
+	return db.instance.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(pendingKey, string(data), nil)
+		return err
+	})
+}
+
+// ReadPending fetches the unknown cards that have been put on the lid lately, newest first.
+func (db *DB) ReadPending() ([]player.PendingCard, error) {
+	var cards []player.PendingCard
+	err := db.instance.View(func(tx *buntdb.Tx) error {
+		s, err := tx.Get(pendingKey)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(s), &cards)
+	})
+	return cards, err
+}
+
+// AddUsage adds to the time that has been spent playing on the given day, and returns the new total.
+func (db *DB) AddUsage(day string, d time.Duration) (time.Duration, error) {
+	var total time.Duration
+	err := db.instance.Update(func(tx *buntdb.Tx) error {
+		var err error
+		total, err = readUsage(tx, day)
+		if err != nil {
+			return err
+		}
+		total += d
+		_, _, err = tx.Set(getUsageKey(day), strconv.FormatInt(int64(total/time.Second), 10), nil)
+		return err
+	})
+	return total, err
+}
+
+// ReadUsage fetches the time that has been spent playing on the given day.
+func (db *DB) ReadUsage(day string) (time.Duration, error) {
+	var total time.Duration
+	err := db.instance.View(func(tx *buntdb.Tx) error {
+		var err error
+		total, err = readUsage(tx, day)
+		return err
+	})
+	return total, err
+}
+
+func readUsage(tx *buntdb.Tx, day string) (time.Duration, error) {
+	s, err := tx.Get(getUsageKey(day))
+	if err == buntdb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// StoreRules saves the rules that the player is running with, so that they can be shown by other commands.
+func (db *DB) StoreRules(r player.Rules) error {
+	return db.instance.Update(func(tx *buntdb.Tx) error {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(rulesKey, string(data), nil)
+		return err
+	})
+}
+
+func (db *DB) ReadRules() (player.Rules, error) {
+	var r player.Rules
+	err := db.instance.View(func(tx *buntdb.Tx) error {
+		s, err := tx.Get(rulesKey)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(s), &r)
+	})
+	return r, err
+}
+
+// StoreOverride saves the time until which the rules are lifted. The zero time ends an override.
+func (db *DB) StoreOverride(until time.Time) error {
+	return db.instance.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(overrideKey, until.Format(time.RFC3339), nil)
+		return err
+	})
+}
+
+// ReadOverride reads the time until which the rules are lifted. The zero time is returned if there is no override.
+func (db *DB) ReadOverride() (time.Time, error) {
+	var until time.Time
+	err := db.instance.View(func(tx *buntdb.Tx) error {
+		s, err := tx.Get(overrideKey)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		until, err = time.Parse(time.RFC3339, s)
+		return err
+	})
+	return until, err
+}
+
+// AddPlay records that a card started playing. Only the latest plays are kept.
+func (db *DB) AddPlay(p player.Play) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return db.instance.Update(func(tx *buntdb.Tx) error {
+		if _, _, err := tx.Set(getHistoryKey(p.Time), string(data), nil); err != nil {
+			return err
+		}
+
+		var old []string
+		count := 0
+		err := tx.DescendKeys(historyPattern, func(key, _ string) bool {
+			count++
+			if count > maxHistory {
+				old = append(old, key)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range old {
+			if _, err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReadHistory fetches the latest plays, newest first.
+func (db *DB) ReadHistory(limit int) ([]player.Play, error) {
+	var plays []player.Play
+	err := db.instance.View(func(tx *buntdb.Tx) error {
+		var parseErr error
+		err := tx.DescendKeys(historyPattern, func(key, value string) bool {
+			var p player.Play
+			if parseErr = json.Unmarshal([]byte(value), &p); parseErr != nil {
+				return false
+			}
+			plays = append(plays, p)
+			return len(plays) < limit
+		})
+		if err != nil {
+			return err
+		}
+		return parseErr
+	})
+	return plays, err
+}
+
+// Verify goes through every record in the database, and describes the ones that can't be parsed.
+func (db *DB) Verify() ([]string, error) {
+	var problems []string
+	err := db.instance.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			if err := verifyRecord(tx, key, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: %v", key, err))
+			}
+			return true
+		})
+	})
+	return problems, err
+}
+
+func verifyRecord(tx *buntdb.Tx, key, value string) error {
+	kind, id, _ := strings.Cut(key, ":")
+	switch {
+	case key == activeKey:
+		// the active card is deleted rather than stored empty when nothing plays, and it is always a known card.
+		if value == "" || strings.ContainsAny(value, " \t\r\n:") {
+			return fmt.Errorf("%q is not a card ID", value)
+		}
+		if _, err := tx.Get(getCardKey(value)); err == buntdb.ErrNotFound {
+			return fmt.Errorf("the active card %v is not stored", value)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	case key == pendingKey:
+		var cards []player.PendingCard
+		return json.Unmarshal([]byte(value), &cards)
+	case key == rulesKey:
+		var r player.Rules
+		return json.Unmarshal([]byte(value), &r)
+	case key == overrideKey:
+		_, err := time.Parse(time.RFC3339, value)
+		return err
+	case kind == "card":
+		var c sonos.CardInfo
+		if err := json.Unmarshal([]byte(value), &c); err != nil {
+			return err
+		}
+		if c.ID != id {
+			return fmt.Errorf("the card is stored with the ID %v", c.ID)
+		}
+		if c.AlbumIDString() == "" && c.PlaylistIDString() == "" && c.LocalPath == "" && c.Stream == nil &&
+			c.Favorite == nil && c.Action == nil {
+			return errors.New("the card has nothing to play")
+		}
+		return nil
+	case kind == "history":
+		var p player.Play
+		return json.Unmarshal([]byte(value), &p)
+	case kind == "usage":
+		if _, err := time.Parse("2006-01-02", id); err != nil {
+			return err
+		}
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err
+	}
+	return errors.New("unknown kind of record")
+}
+
+func getHistoryKey(t time.Time) string {
+	// zero padded, so that the keys sort by time.
+	return fmt.Sprintf("history:%020d", t.UnixNano())
+}
+
+func getUsageKey(day string) string {
+	return fmt.Sprintf("usage:%v", day)
+}
+
 func getCardKey(id string) string {
 	return fmt.Sprintf("card:%v", id)
 }
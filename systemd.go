@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/nfc"
+	"github.com/callebjorkell/rpi-nfc-player/player"
+	sd "github.com/coreos/go-systemd/v22/daemon"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// healthTimeout is how long the card reader and the event loop of the player may go without doing anything, before
+// they are considered hung and the watchdog is no longer pinged.
+const healthTimeout = 15 * time.Second
+
+// notifySystemd tells systemd that the player is ready, keeps the status of the service up to date with what the player
+// is doing, and pings the watchdog for as long as the card reader and the player are healthy. It does nothing when the
+// player isn't run by systemd.
+func notifySystemd(p *player.Player, reader nfc.CardReader) {
+	if ok, err := sd.SdNotify(false, sd.SdNotifyReady); err != nil {
+		log.Warn("Could not notify systemd: ", err)
+		return
+	} else if !ok {
+		log.Debug("Not running under systemd, not sending notifications")
+		return
+	}
+	log.Info("Notified systemd that the player is ready")
+
+	go func() {
+		for s := range p.Watch() {
+			notify("STATUS=" + serviceStatus(s))
+		}
+	}()
+
+	interval, err := sd.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Warn("Could not read the watchdog settings: ", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	log.Infof("Pinging the systemd watchdog every %v", interval/2)
+	go func() {
+		for range time.Tick(interval / 2) {
+			if err := healthy(p, reader); err != nil {
+				log.Error("Not pinging the watchdog: ", err)
+				continue
+			}
+			notify(sd.SdNotifyWatchdog)
+		}
+	}()
+}
+
+func notify(state string) {
+	if _, err := sd.SdNotify(false, state); err != nil {
+		log.Debug("Could not notify systemd: ", err)
+	}
+}
+
+// healthy tells if both the card reader and the event loop of the player are still going.
+func healthy(p *player.Player, reader nfc.CardReader) error {
+	if since := time.Since(reader.Polled()); since > healthTimeout {
+		return fmt.Errorf("the card reader has not polled for %v", since.Round(time.Second))
+	}
+	if since := time.Since(p.Alive()); since > healthTimeout {
+		return fmt.Errorf("the player has not handled anything for %v", since.Round(time.Second))
+	}
+	return nil
+}
+
+func serviceStatus(s player.Status) string {
+	if s.Card == nil {
+		return s.State.String()
+	}
+	card := s.Card.Title
+	if card == "" {
+		card = "card " + s.Card.ID
+	}
+	return fmt.Sprintf("%v: %v", s.State, card)
+}
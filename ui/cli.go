@@ -3,71 +3,363 @@
 package ui
 
 import (
-	"github.com/sirupsen/logrus"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// clickDuration is how long a button is held down when it's clicked.
+const clickDuration = 150 * time.Millisecond
+
+var ch = make(chan ButtonEvent, 10)
+
+// When standard input is a terminal, the buttons are pressed with the keyboard, and the LED and the tiger are shown on
+// a status line at the bottom of the terminal. Otherwise the LED and the tiger are logged.
+var (
+	startOnce sync.Once
+	term      *terminal
 )
 
-var ch = make(chan ButtonEvent, 1)
+// startTerminal takes over the terminal, if there is one.
+func startTerminal() {
+	startOnce.Do(func() {
+		restore, err := cbreak()
+		if err != nil {
+			log.Debug("Not reading keys, standard input is not a terminal: ", err)
+			return
+		}
+		term = &terminal{led: "off", restore: restore}
+		term.resize()
+		// restores the terminal when the player exits, be it from a signal or a fatal error.
+		logrus.RegisterExitHandler(term.close)
+		log.Info("Keys: r and b click red and blue, R and B hold them down, t flips the tiger switch, " +
+			": types a card reader command, and ctrl-L redraws")
+		go term.readKeys()
+	})
+}
 
 func InitButtons() <-chan ButtonEvent {
+	startTerminal()
+	return ch
+}
+
+// ReaderInput returns the card reader commands that are typed in to the terminal, since the keys take standard input.
+// It's nil when standard input isn't a terminal, so the commands can be read from it directly.
+func ReaderInput() io.Reader {
+	startTerminal()
+	if term == nil {
+		return nil
+	}
+	r, w := io.Pipe()
+	commands := make(chan string, 10)
 	go func() {
-		<-time.After(20 * time.Second)
-		ch <- ButtonEvent{
-			Pressed: true,
-			Button:  TigerSwitch,
+		for c := range commands {
+			fmt.Fprintln(w, c)
 		}
 	}()
-	return ch
+	term.lock.Lock()
+	term.commands = commands
+	term.lock.Unlock()
+	return r
+}
+
+// Close gives the terminal back the way it was, if the keys took it over.
+func Close() {
+	if term != nil {
+		term.close()
+	}
+}
+
+// CheckHardware has no hardware to check.
+func CheckHardware() error {
+	return ErrNoHardware
 }
 
 func InitTiger() Tiger {
+	startTerminal()
 	return cliTiger{}
 }
 
 func GetColorLED() ColorLed {
+	startTerminal()
 	return cliLed{}
 }
 
 type cliLed struct{}
 
 func (cliLed) Purple() {
-	logrus.Println("LED: Purple")
+	showLed("Purple")
 }
 
 func (cliLed) Yellow() {
-	logrus.Println("LED: Yellow")
+	showLed("Yellow")
 }
 
 func (cliLed) Cyan() {
-	logrus.Println("LED: Cyan")
+	showLed("Cyan")
 }
 
 func (cliLed) Red() {
-	logrus.Println("LED: Red")
+	showLed("Red")
 }
 
 func (cliLed) Green() {
-	logrus.Println("LED: Green")
+	showLed("Green")
 }
 
 func (cliLed) Blue() {
-	logrus.Println("LED: Blue")
+	showLed("Blue")
 }
 
 func (cliLed) Off() {
-	logrus.Println("LED: Off")
+	showLed("Off")
+}
+
+func showLed(color string) {
+	if term == nil {
+		log.Println("LED:", color)
+		return
+	}
+	term.lock.Lock()
+	defer term.lock.Unlock()
+	term.led = strings.ToLower(color)
+	term.draw()
 }
 
 type cliTiger struct{}
 
 func (cliTiger) Off() {
-	logrus.Println("Tiger deactivated")
+	showTiger(false)
 }
 
 func (cliTiger) On() {
-	logrus.Println("Tiger activated")
-	ch <- ButtonEvent{
-		Pressed: false,
-		Button:  TigerSwitch,
+	showTiger(true)
+}
+
+func showTiger(out bool) {
+	if term == nil {
+		if out {
+			log.Println("Tiger activated")
+		} else {
+			log.Println("Tiger deactivated")
+		}
+		return
+	}
+	term.lock.Lock()
+	defer term.lock.Unlock()
+	term.tiger = out
+	term.draw()
+}
+
+// terminal is the state of the box as shown on the status line.
+type terminal struct {
+	lock sync.Mutex
+	led  string
+	// tiger is set while the tiger is out, and pressed has the buttons that are held down.
+	tiger   bool
+	pressed [3]bool
+	// events are the button events to send once the lock is let go, so that the LED can be shown meanwhile.
+	events []ButtonEvent
+	// prompt is the card reader command that is being typed, or nil when none is.
+	prompt   *string
+	commands chan string
+	rows     int
+	restore  func()
+}
+
+func (t *terminal) readKeys() {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		k, err := r.ReadByte()
+		if err != nil {
+			log.Debug("Stopped reading keys: ", err)
+			return
+		}
+		t.key(k)
+	}
+}
+
+func (t *terminal) key(k byte) {
+	t.lock.Lock()
+	defer t.send()
+	defer t.lock.Unlock()
+	if t.prompt != nil {
+		t.edit(k)
+		t.draw()
+		return
+	}
+	switch k {
+	case 'r':
+		t.click(Red)
+	case 'b':
+		t.click(Blue)
+	case 'R':
+		t.press(Red, !t.pressed[Red])
+	case 'B':
+		t.press(Blue, !t.pressed[Blue])
+	case 't':
+		t.press(TigerSwitch, !t.pressed[TigerSwitch])
+	case ':':
+		if t.commands != nil {
+			prompt := ""
+			t.prompt = &prompt
+		}
+	case 12:
+		// ctrl-L
+		t.resize()
 	}
+	t.draw()
+}
+
+// edit types a key in to the card reader command, which is sent on enter, and dropped on escape.
+func (t *terminal) edit(k byte) {
+	switch {
+	case k == '\n' || k == '\r':
+		select {
+		case t.commands <- *t.prompt:
+		default:
+			log.Warn("The card reader is not taking commands, dropping ", *t.prompt)
+		}
+		t.prompt = nil
+	case k == 27:
+		t.prompt = nil
+	case k == 127 || k == 8:
+		if p := *t.prompt; len(p) > 0 {
+			*t.prompt = p[:len(p)-1]
+		}
+	case k >= ' ' && k < 127:
+		*t.prompt += string(k)
+	}
+}
+
+// click presses a button, and lets it go again a moment later.
+func (t *terminal) click(b Button) {
+	if t.pressed[b] {
+		return
+	}
+	t.press(b, true)
+	time.AfterFunc(clickDuration, func() {
+		t.lock.Lock()
+		defer t.send()
+		defer t.lock.Unlock()
+		t.press(b, false)
+		t.draw()
+	})
+}
+
+func (t *terminal) press(b Button, pressed bool) {
+	t.pressed[b] = pressed
+	t.events = append(t.events, ButtonEvent{Pressed: pressed, Button: b})
+}
+
+// send sends the button events that were queued. The player takes the lock to show the LED, so the events can't be sent
+// while holding it.
+func (t *terminal) send() {
+	t.lock.Lock()
+	events := t.events
+	t.events = nil
+	t.lock.Unlock()
+	for _, e := range events {
+		ch <- e
+	}
+}
+
+// resize makes room for the status line at the bottom of the terminal, and lets everything else scroll above it.
+func (t *terminal) resize() {
+	size, err := stty("size")
+	if err == nil {
+		fields := strings.Fields(size)
+		if len(fields) == 2 {
+			t.rows, err = strconv.Atoi(fields[0])
+		}
+	}
+	if err != nil || t.rows < 2 {
+		t.rows = 24
+	}
+	// scroll up a line in case the cursor is at the bottom, set the scroll region, and move back in to it.
+	fmt.Printf("\n\033[1;%dr\033[%d;1H", t.rows-1, t.rows-1)
+}
+
+func (t *terminal) draw() {
+	line := fmt.Sprintf("LED %v   tiger %v (switch %v)   red %v   blue %v", colored(t.led), inOrOut(t.tiger),
+		onOrOff(t.pressed[TigerSwitch]), upOrDown(t.pressed[Red]), upOrDown(t.pressed[Blue]))
+	if t.prompt != nil {
+		line += "   card reader: " + *t.prompt + "_"
+	}
+	fmt.Printf("\0337\033[%d;1H\033[2K%v\0338", t.rows, line)
+}
+
+// close gives the terminal back the way it was. It does nothing when the terminal has already been given back, since
+// the player can both return and exit through logrus.
+func (t *terminal) close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.restore == nil {
+		return
+	}
+	fmt.Printf("\0337\033[%d;1H\033[2K\033[r\0338\n", t.rows)
+	t.restore()
+	t.restore = nil
+}
+
+// colored is the name of the color of the LED, in that color.
+func colored(led string) string {
+	codes := map[string]string{
+		"red": "31", "green": "32", "yellow": "33", "blue": "34", "purple": "35", "cyan": "36",
+	}
+	if code, ok := codes[led]; ok {
+		return "\033[1;" + code + "m● " + led + "\033[0m"
+	}
+	return "○ " + led
+}
+
+func inOrOut(out bool) string {
+	if out {
+		return "out"
+	}
+	return "in"
+}
+
+func onOrOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func upOrDown(down bool) string {
+	if down {
+		return "down"
+	}
+	return "up"
+}
+
+// cbreak makes the terminal hand over keys as they are pressed, without echoing them, and returns how to undo it. It
+// fails when standard input isn't a terminal.
+func cbreak() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() {
+		stty(strings.TrimSpace(saved))
+	}, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
 }
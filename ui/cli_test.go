@@ -0,0 +1,114 @@
+//go:build !pi
+
+package ui
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// quiet drops what is written to standard output for the rest of the test, since that's where the status line is drawn.
+func quiet(t *testing.T) {
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+func TestKeys(t *testing.T) {
+	quiet(t)
+	pressed := func(b Button) ButtonEvent { return ButtonEvent{Pressed: true, Button: b} }
+	released := func(b Button) ButtonEvent { return ButtonEvent{Pressed: false, Button: b} }
+	tests := []struct {
+		name string
+		keys string
+		// reader is set when the card reader takes commands from the terminal.
+		reader bool
+		// events are the button events that are sent, and commands the card reader commands.
+		events   []ButtonEvent
+		commands []string
+	}{
+		{name: "click red", keys: "r", events: []ButtonEvent{pressed(Red), released(Red)}},
+		{name: "click blue", keys: "b", events: []ButtonEvent{pressed(Blue), released(Blue)}},
+		{name: "hold red", keys: "RR", events: []ButtonEvent{pressed(Red), released(Red)}},
+		{name: "held button isn't clicked", keys: "Bb", events: []ButtonEvent{pressed(Blue)}},
+		{name: "flip the tiger switch", keys: "tt", events: []ButtonEvent{pressed(TigerSwitch), released(TigerSwitch)}},
+		{name: "unknown keys are ignored", keys: "x?\x1b"},
+		{name: "no prompt without a card reader", keys: ":r", events: []ButtonEvent{pressed(Red), released(Red)}},
+		{name: "card reader command", keys: ":tap 04a2\r", reader: true, commands: []string{"tap 04a2"}},
+		{name: "command ends on a newline too", keys: ":remove\n", reader: true, commands: []string{"remove"}},
+		{name: "backspace", keys: ":tapx\x7f\b 1\r", reader: true, commands: []string{"ta 1"}},
+		{name: "escape drops the command", keys: ":tap 1\x1br", reader: true,
+			events: []ButtonEvent{pressed(Red), released(Red)}},
+		{name: "buttons are typed in to the command", keys: ":rbt\r", reader: true, commands: []string{"rbt"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := &terminal{led: "off", rows: 24}
+			if test.reader {
+				term.commands = make(chan string, 10)
+			}
+			for i := 0; i < len(test.keys); i++ {
+				term.key(test.keys[i])
+			}
+
+			var events []ButtonEvent
+			timeout := time.After(5 * clickDuration)
+			for len(events) < len(test.events) {
+				select {
+				case e := <-ch:
+					events = append(events, e)
+				case <-timeout:
+					t.Fatalf("expected the events %v, got %v", test.events, events)
+				}
+			}
+			// give the clicks time to be let go, in case more is sent than expected.
+			time.Sleep(2 * clickDuration)
+		drain:
+			for {
+				select {
+				case e := <-ch:
+					events = append(events, e)
+				default:
+					break drain
+				}
+			}
+			if len(events) > 0 || len(test.events) > 0 {
+				if !reflect.DeepEqual(events, test.events) {
+					t.Errorf("expected the events %v, got %v", test.events, events)
+				}
+			}
+
+			var commands []string
+			if test.reader {
+				close(term.commands)
+				for c := range term.commands {
+					commands = append(commands, c)
+				}
+			}
+			if !reflect.DeepEqual(commands, test.commands) {
+				t.Errorf("expected the commands %v, got %v", test.commands, commands)
+			}
+		})
+	}
+}
+
+// TestClose checks that the terminal is given back only once, since the player can both return and exit through logrus.
+func TestClose(t *testing.T) {
+	quiet(t)
+	restored := 0
+	term := &terminal{rows: 24, restore: func() { restored++ }}
+	term.close()
+	term.close()
+	if restored != 1 {
+		t.Errorf("expected the terminal to be restored once, it was restored %v times", restored)
+	}
+}
@@ -3,7 +3,8 @@
 package ui
 
 import (
-	"github.com/sirupsen/logrus"
+	"fmt"
+	"io"
 	"periph.io/x/conn/v3/gpio"
 	"periph.io/x/conn/v3/gpio/gpioreg"
 	"periph.io/x/host/v3"
@@ -16,12 +17,72 @@ const (
 	bluePin        = "GPIO20"
 	tigerSwitchPin = "GPIO16"
 	tigerPin       = "GPIO23"
+	ledRedPin      = "GPIO6"
+	ledGreenPin    = "GPIO5"
+	ledBluePin     = "GPIO13"
+
+	// checkDelay is how long each color and the tiger are shown when checking the hardware.
+	checkDelay = 700 * time.Millisecond
 )
 
+// hostErr is why periph could not be initialized, if it couldn't. The pins can't be used then.
+var hostErr error
+
 func init() {
-	if _, err := host.Init(); err != nil {
-		logrus.Fatalln("Unable to initialize periph:", err)
+	_, hostErr = host.Init()
+}
+
+func mustInit() {
+	if hostErr != nil {
+		log.Fatalln("Unable to initialize periph:", hostErr)
+	}
+}
+
+// CheckHardware checks that all the pins can be claimed, and then cycles the LED through red, green and blue and
+// brings out the tiger, so that they can be checked by eye.
+func CheckHardware() error {
+	if hostErr != nil {
+		return fmt.Errorf("unable to initialize periph: %w", hostErr)
+	}
+	for _, name := range []string{redPin, bluePin, tigerSwitchPin} {
+		pin := gpioreg.ByName(name)
+		if pin == nil {
+			return fmt.Errorf("there is no pin %v", name)
+		}
+		if err := pin.In(gpio.PullUp, gpio.NoEdge); err != nil {
+			return fmt.Errorf("could not claim %v: %w", name, err)
+		}
 	}
+	for _, name := range []string{tigerPin, ledRedPin, ledGreenPin, ledBluePin} {
+		pin := gpioreg.ByName(name)
+		if pin == nil {
+			return fmt.Errorf("there is no pin %v", name)
+		}
+		if err := pin.Out(gpio.High); err != nil {
+			return fmt.Errorf("could not claim %v: %w", name, err)
+		}
+	}
+
+	led := GetColorLED()
+	for _, color := range []func(){led.Red, led.Green, led.Blue} {
+		color()
+		time.Sleep(checkDelay)
+	}
+	led.Off()
+
+	tiger := InitTiger()
+	tiger.On()
+	time.Sleep(checkDelay)
+	tiger.Off()
+	return nil
+}
+
+// Close has nothing to give back, the pins are left as they are.
+func Close() {}
+
+// ReaderInput is nil, since the Raspberry Pi has a real card reader.
+func ReaderInput() io.Reader {
+	return nil
 }
 
 var buttonStates [3]bool
@@ -82,11 +143,12 @@ func (c *colorLed) Off() {
 }
 
 func GetColorLED() ColorLed {
-	logrus.Infoln("Initializing LED")
+	mustInit()
+	log.Infoln("Initializing LED")
 
-	redLED := gpioreg.ByName("GPIO6")
-	greenLED := gpioreg.ByName("GPIO5")
-	blueLED := gpioreg.ByName("GPIO13")
+	redLED := gpioreg.ByName(ledRedPin)
+	greenLED := gpioreg.ByName(ledGreenPin)
+	blueLED := gpioreg.ByName(ledBluePin)
 
 	c := colorLed{r: redLED, g: greenLED, b: blueLED}
 	c.Off()
@@ -103,6 +165,7 @@ func (t tiger) Off() {
 
 // InitTiger fetches and resets the tiger pin
 func InitTiger() Tiger {
+	mustInit()
 	pin := gpioreg.ByName(tigerPin)
 	t := tiger{pin: pin}
 	t.Off()
@@ -112,7 +175,8 @@ func InitTiger() Tiger {
 
 // InitButtons initializes all the button pins and fetches a button event channel
 func InitButtons() <-chan ButtonEvent {
-	logrus.Infoln("Initializing buttons")
+	mustInit()
+	log.Infoln("Initializing buttons")
 	redButton := gpioreg.ByName(redPin)
 	blueButton := gpioreg.ByName(bluePin)
 	tigerSwitch := gpioreg.ByName(tigerSwitchPin)
@@ -128,9 +192,9 @@ func InitButtons() <-chan ButtonEvent {
 }
 
 func handleButton(b gpio.PinIO, t Button, c chan ButtonEvent, initialized *sync.WaitGroup) {
-	logrus.Debugln("Handling button ", b.Name())
+	log.Debugln("Handling button ", b.Name())
 	if err := b.In(gpio.PullUp, gpio.BothEdges); err != nil {
-		logrus.Fatal(err)
+		log.Fatal(err)
 	}
 
 	last := gpio.High
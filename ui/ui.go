@@ -1,9 +1,16 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
+	"github.com/callebjorkell/rpi-nfc-player/logging"
 )
 
+var log = logging.For("ui")
+
+// ErrNoHardware is returned when checking the hardware of a build that isn't made for the Raspberry Pi.
+var ErrNoHardware = errors.New("not built for the Raspberry Pi")
+
 const (
 	Red         Button = 0
 	Blue        Button = 1
@@ -0,0 +1,267 @@
+'use strict';
+
+// Everything the interface needs is served by the player itself, so that it works without an internet connection.
+
+const $ = (id) => document.getElementById(id);
+let token = localStorage.getItem('token') || '';
+let currentTab = 'now';
+let lastAssigned = null;
+
+function showError(message) {
+  const e = $('error');
+  e.textContent = message;
+  e.hidden = !message;
+}
+
+async function api(path, options = {}) {
+  options.headers = Object.assign({'Authorization': 'Bearer ' + token}, options.headers);
+  const res = await fetch(path, options);
+  if (res.status === 401) {
+    showLogin();
+    throw new Error('Please log in');
+  }
+  if (res.status === 204) {
+    return null;
+  }
+  const body = await res.json();
+  if (!res.ok) {
+    throw new Error(body.error || res.statusText);
+  }
+  return body;
+}
+
+// withToken adds the token to links and images, since those can't carry headers.
+function withToken(path) {
+  return path + (path.includes('?') ? '&' : '?') + 'token=' + encodeURIComponent(token);
+}
+
+function el(tag, props = {}, children = []) {
+  const e = document.createElement(tag);
+  Object.assign(e, props);
+  children.forEach((c) => e.append(c));
+  return e;
+}
+
+function cardTitle(c) {
+  if (c.title) {
+    return c.title;
+  }
+  if (c.stream) {
+    return c.stream.name;
+  }
+  if (c.action) {
+    return c.action.type + (c.action.value ? ' ' + c.action.value : '');
+  }
+  if (c.favorite) {
+    return c.favorite.title;
+  }
+  return c.id;
+}
+
+function cardKind(c) {
+  if (c.stream) {
+    return 'Radio';
+  }
+  if (c.action) {
+    return 'Control card';
+  }
+  if (c.favorite) {
+    return 'Sonos favorite';
+  }
+  if (c.localPath) {
+    return 'Local album';
+  }
+  const service = c.service || 'deezer';
+  return service.charAt(0).toUpperCase() + service.slice(1) + (c.playlistId || c.servicePlaylistId ? ' playlist' : ' album');
+}
+
+function cover(id, className) {
+  return el('img', {src: withToken('/api/cards/' + encodeURIComponent(id) + '/cover'), alt: '', loading: 'lazy', className: className || ''});
+}
+
+function showLogin() {
+  $('login').hidden = false;
+  document.querySelectorAll('.tab').forEach((t) => t.hidden = true);
+}
+
+function showTab(name) {
+  currentTab = name;
+  $('login').hidden = true;
+  showError('');
+  document.querySelectorAll('nav button').forEach((b) => b.classList.toggle('active', b.dataset.tab === name));
+  document.querySelectorAll('.tab').forEach((t) => t.hidden = t.id !== name);
+  refresh();
+}
+
+function refresh() {
+  const load = {now: loadState, add: loadState, cards: loadCards, history: loadHistory}[currentTab];
+  load().catch((e) => showError(e.message));
+}
+
+async function loadState() {
+  const s = await api('/api/state');
+  const p = s.player;
+
+  $('now-state').textContent = p.state;
+  $('now-speaker').textContent = 'Speaker: ' + s.speaker;
+  $('now-title').textContent = p.card ? cardTitle(p.card) : 'Nothing on the lid';
+  $('now-track').textContent = s.track ? [s.track.title, s.track.artist].filter(Boolean).join(' – ') : '';
+  const img = $('now-cover');
+  if (p.card && p.card.id) {
+    const src = withToken('/api/cards/' + encodeURIComponent(p.card.id) + '/cover');
+    if (img.getAttribute('src') !== src) {
+      img.src = src;
+    }
+    img.hidden = false;
+  } else {
+    img.hidden = true;
+  }
+
+  $('assigning').hidden = !p.assigning;
+  if (p.assigning) {
+    $('assigning-title').textContent = cardTitle(p.assigning);
+  }
+  if (lastAssigned !== null && p.assigned && p.assigned !== lastAssigned) {
+    showError('');
+    alert('Card ' + p.assigned + ' is ready to play!');
+  }
+  lastAssigned = p.assigned || '';
+}
+
+async function command(cmd) {
+  try {
+    await api('/api/player/' + cmd, {method: 'POST'});
+    await loadState();
+  } catch (e) {
+    showError(e.message);
+  }
+}
+
+async function search(query) {
+  const res = await api('/api/search?q=' + encodeURIComponent(query));
+  const list = $('results');
+  list.replaceChildren();
+  if (!res.data || res.data.length === 0) {
+    list.append(el('li', {textContent: 'No matches. Try something else.'}));
+    return;
+  }
+  res.data.forEach((r) => {
+    const art = r.cover_medium || r.picture_medium;
+    const bind = el('button', {textContent: 'Bind', onclick: () => assign(r)});
+    list.append(el('li', {}, [
+      art ? el('img', {src: art, alt: '', loading: 'lazy'}) : '',
+      el('div', {className: 'text'}, [
+        el('p', {textContent: r.title}),
+        el('p', {className: 'sub', textContent: [r.artist && r.artist.name, r.type].filter(Boolean).join(' · ')}),
+      ]),
+      bind,
+    ]));
+  });
+}
+
+async function assign(result) {
+  const body = result.type === 'playlist' ? {playlistId: result.id} : {albumId: result.id};
+  try {
+    await api('/api/assign', {method: 'POST', body: JSON.stringify(body)});
+    await loadState();
+  } catch (e) {
+    showError(e.message);
+  }
+}
+
+async function loadCards() {
+  const cards = (await api('/api/cards')) || [];
+  const labels = await api('/api/labels');
+  cards.sort((a, b) => cardTitle(a).localeCompare(cardTitle(b)));
+
+  $('card-count').textContent = cards.length + ' cards';
+  const sheets = $('sheets');
+  sheets.replaceChildren('Sheets: ');
+  for (let i = 0; i < labels.sheets; i++) {
+    sheets.append(el('a', {href: withToken('/api/labels/sheet?page=' + i), textContent: String(i + 1), download: ''}), ' ');
+  }
+
+  const list = $('card-list');
+  list.replaceChildren();
+  cards.forEach((c) => {
+    const remove = el('button', {
+      textContent: 'Remove',
+      onclick: async () => {
+        if (!confirm('Remove ' + cardTitle(c) + ' from card ' + c.id + '?')) {
+          return;
+        }
+        try {
+          await api('/api/cards/' + encodeURIComponent(c.id), {method: 'DELETE'});
+          await loadCards();
+        } catch (e) {
+          showError(e.message);
+        }
+      },
+    });
+    list.append(el('li', {}, [
+      cover(c.id),
+      el('div', {className: 'text'}, [
+        el('p', {textContent: cardTitle(c)}),
+        el('p', {className: 'sub', textContent: cardKind(c)}),
+      ]),
+      el('div', {className: 'actions'}, [
+        el('a', {href: withToken('/api/cards/' + encodeURIComponent(c.id) + '/label'), textContent: 'Label', download: ''}),
+        remove,
+      ]),
+    ]));
+  });
+}
+
+async function loadHistory() {
+  const plays = await api('/api/history');
+  const list = $('history-list');
+  list.replaceChildren();
+  if (plays.length === 0) {
+    list.append(el('li', {textContent: 'Nothing has been played yet.'}));
+  }
+  plays.forEach((p) => {
+    list.append(el('li', {}, [
+      cover(p.cardId),
+      el('div', {className: 'text'}, [
+        el('p', {textContent: p.title || p.cardId}),
+        el('p', {className: 'sub', textContent: new Date(p.time).toLocaleString()}),
+      ]),
+    ]));
+  });
+}
+
+document.querySelectorAll('nav button').forEach((b) => b.addEventListener('click', () => showTab(b.dataset.tab)));
+document.querySelectorAll('.controls button').forEach((b) => b.addEventListener('click', () => command(b.dataset.cmd)));
+
+$('login-form').addEventListener('submit', (e) => {
+  e.preventDefault();
+  token = $('token').value;
+  localStorage.setItem('token', token);
+  showTab(currentTab);
+});
+
+$('search-form').addEventListener('submit', (e) => {
+  e.preventDefault();
+  search($('query').value).catch((err) => showError(err.message));
+});
+
+$('assign-cancel').addEventListener('click', async () => {
+  try {
+    await api('/api/assign', {method: 'DELETE'});
+    await loadState();
+  } catch (e) {
+    showError(e.message);
+  }
+});
+
+setInterval(() => {
+  if (!document.hidden && (currentTab === 'now' || currentTab === 'add') && $('login').hidden) {
+    loadState().catch(() => {});
+  }
+}, 3000);
+
+if (token) {
+  showTab('now');
+} else {
+  showLogin();
+}